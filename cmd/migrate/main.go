@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/korjavin/ragtgbot/internal/config"
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+	"github.com/korjavin/ragtgbot/internal/resilient"
+	"github.com/korjavin/ragtgbot/internal/schema"
+)
+
+func main() {
+	configPath := flag.String("config", os.Getenv(config.FileEnv), "YAML or TOML config file, environment variables override it")
+	collection := flag.String("collection", "", "alias of the collection to migrate (default qdrant.collection)")
+	dryRun := flag.Bool("dry-run", false, "only print the migration plan")
+	dropLegacy := flag.Bool("drop-legacy", false, "delete a legacy unaliased collection once copied and create the alias in its place; stop the bot first")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	if *collection == "" {
+		*collection = cfg.Qdrant.Collection
+	}
+	client := qdrant.NewClient(cfg.Qdrant.Address, resilient.NewLoggingClient("qdrant", resilient.DefaultPolicy(cfg.Qdrant.Timeout)))
+	ctx := context.Background()
+
+	plan, err := schema.PlanMigration(ctx, client, *collection)
+	if qdrant.IsNotFound(err) {
+		log.Printf("Collection '%s' does not exist, nothing to migrate", *collection)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to inspect collection '%s': %v", *collection, err)
+	}
+	plan.DropLegacy = *dropLegacy
+
+	log.Printf("Migration plan: %s", plan)
+	if !plan.NeedsMigration() || *dryRun {
+		return
+	}
+
+	err = schema.Migrate(ctx, client, plan, func(copied int64) {
+		log.Printf("Copied %d points", copied)
+	})
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+	if plan.KeepsLegacy() {
+		log.Printf("Copied '%s' into '%s', the legacy collection is still in use. Stop the bot and run again with -drop-legacy to replace it with the alias.",
+			*collection, plan.Target)
+		return
+	}
+	log.Printf("Collection '%s' now points at '%s' (schema version %d)", *collection, plan.Target, schema.CurrentVersion)
+}
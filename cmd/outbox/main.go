@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/korjavin/ragtgbot/internal/config"
+	"github.com/korjavin/ragtgbot/internal/embedding"
+	"github.com/korjavin/ragtgbot/internal/outbox"
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+	"github.com/korjavin/ragtgbot/internal/resilient"
+	"github.com/korjavin/ragtgbot/internal/schema"
+)
+
+// previewLength is the number of characters of the chunk text shown by list
+const previewLength = 60
+
+const usage = `Usage: go run ./cmd/outbox [-config FILE] [-dir DIR | -import] <command>
+
+Commands:
+  list [-pending]   show the dead letters, or the chunks still being retried
+  replay [-id IDS]  store the dead letters again, or only those with the
+                    given comma-separated point IDs; stop the bot (with
+                    -import: the import) first
+
+The outbox directory defaults to outbox.dir of the configuration, the bot's
+outbox; -import selects the one of backup imports inside it.
+`
+
+func main() {
+	configPath := flag.String("config", os.Getenv(config.FileEnv), "YAML or TOML config file, environment variables override it")
+	dir := flag.String("dir", "", "outbox directory")
+	importOutbox := flag.Bool("import", false, "use the outbox of backup imports")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	if *dir == "" {
+		*dir = cfg.Outbox.Dir
+	}
+	if *dir == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *importOutbox {
+		*dir = outbox.ImportDir(*dir)
+	}
+
+	switch command, args := flag.Arg(0), flag.Args()[1:]; command {
+	case "list":
+		list(*dir, args)
+	case "replay":
+		replay(cfg, *dir, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func list(dir string, args []string) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	pending := flags.Bool("pending", false, "list the chunks still being retried instead of the dead letters")
+	flags.Parse(args)
+
+	var entries []outbox.Entry
+	var err error
+	if *pending {
+		entries, err = outbox.Pending(dir)
+		if err != nil {
+			log.Fatalf("Failed to read pending entries: %v", err)
+		}
+	} else {
+		entries, err = outbox.DeadLetters(dir)
+		if err != nil {
+			log.Fatalf("Failed to read dead letters: %v", err)
+		}
+	}
+
+	for _, entry := range entries {
+		fmt.Printf("%s  chat %v  attempts %d  added %s\n", entry.ID, entry.Payload["chat_id"], entry.Attempts, entry.Added.Format(time.RFC3339))
+		if *pending {
+			fmt.Printf("    next attempt %s\n", entry.NextAttempt.Format(time.RFC3339))
+		}
+		fmt.Printf("    error: %s\n", entry.LastError)
+		fmt.Printf("    text:  %s\n", preview(entry.Text))
+	}
+	fmt.Printf("%d entries\n", len(entries))
+}
+
+func replay(cfg *config.Config, dir string, args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	idList := flags.String("id", "", "comma-separated point IDs to replay, all if empty")
+	flags.Parse(args)
+
+	var ids []qdrant.PointID
+	for _, id := range strings.Split(*idList, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, qdrant.PointID(id))
+		}
+	}
+
+	ctx := context.Background()
+	client := qdrant.NewClient(cfg.Qdrant.Address, resilient.NewLoggingClient("qdrant", resilient.DefaultPolicy(cfg.Qdrant.Timeout)))
+	collectionName := cfg.Qdrant.Collection
+
+	embeddingClient := resilient.NewLoggingClient("embedding", resilient.DefaultPolicy(cfg.Embedding.Timeout))
+	embedder, err := embedding.New(ctx, cfg.Embedding.EmbedderConfig(embeddingClient))
+	if err != nil {
+		log.Fatalf("Failed to set up embedding backend: %v", err)
+	}
+
+	// Points get the same vectors the bot would write
+	status, err := schema.Ensure(ctx, client, collectionName, embedder.Dimension())
+	if err != nil {
+		log.Fatalf("Failed to check collection '%s': %v", collectionName, err)
+	}
+
+	result, err := outbox.Replay(ctx, dir, ids, func(ctx context.Context, entry outbox.Entry) error {
+		embeddings, err := embedder.Embed(ctx, []string{entry.Text})
+		if err != nil {
+			log.Printf("Failed to embed %s: %v", entry.ID, err)
+			return err
+		}
+		point := entry.Point(embeddings[0], status.KeywordSearch())
+		if err := client.Upsert(ctx, collectionName, []qdrant.Point{point}, true); err != nil {
+			log.Printf("Failed to store %s: %v", entry.ID, err)
+			return err
+		}
+		log.Printf("Stored %s", entry.ID)
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Failed to update dead letters: %v", err)
+	}
+	log.Printf("Replayed dead letters: %d stored, %d still failing", result.Stored, result.Dead)
+}
+
+// preview shortens a chunk text to one line
+func preview(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > previewLength {
+		return string(runes[:previewLength]) + "..."
+	}
+	return text
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/korjavin/ragtgbot/internal/config"
+	"github.com/korjavin/ragtgbot/internal/embedding"
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+	"github.com/korjavin/ragtgbot/internal/resilient"
+	"github.com/korjavin/ragtgbot/internal/schema"
+)
+
+func main() {
+	configPath := flag.String("config", os.Getenv(config.FileEnv), "YAML or TOML config file, environment variables override it")
+	collection := flag.String("collection", "", "alias of the collection to reindex (default qdrant.collection)")
+	dryRun := flag.Bool("dry-run", false, "only print the reindex plan")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	if *collection == "" {
+		*collection = cfg.Qdrant.Collection
+	}
+	client := qdrant.NewClient(cfg.Qdrant.Address, resilient.NewLoggingClient("qdrant", resilient.DefaultPolicy(cfg.Qdrant.Timeout)))
+	ctx := context.Background()
+
+	// The new model comes from the same embedding settings the bot uses
+	embeddingClient := resilient.NewLoggingClient("embedding", resilient.DefaultPolicy(cfg.Embedding.Timeout))
+	embedder, err := embedding.New(ctx, cfg.Embedding.EmbedderConfig(embeddingClient))
+	if err != nil {
+		log.Fatalf("Failed to set up embedding backend: %v", err)
+	}
+
+	plan, err := schema.PlanReindex(ctx, client, *collection, embedder)
+	if qdrant.IsNotFound(err) {
+		log.Printf("Collection '%s' does not exist, nothing to reindex", *collection)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to plan reindex of '%s': %v", *collection, err)
+	}
+
+	log.Printf("Reindex plan: %s", plan)
+	if *dryRun {
+		return
+	}
+
+	err = schema.Reindex(ctx, client, plan, embedder, func(progress schema.ReindexProgress) {
+		log.Printf("Pass %d: scanned %d points, embedded %d, skipped %d without text",
+			progress.Pass, progress.Scanned, progress.Embedded, progress.Skipped)
+	})
+	if err != nil {
+		log.Fatalf("Reindex failed, run again to resume: %v", err)
+	}
+	log.Printf("Collection '%s' now points at '%s' (%s); restart the bot with the same embedding settings, '%s' can be deleted once everything works",
+		*collection, plan.Target, plan.ModelID, plan.From.Collection)
+}
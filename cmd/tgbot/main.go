@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -14,8 +17,19 @@ import (
 	"strings"
 	"syscall"
 	"time"
+	_ "time/tzdata" // The alpine image has no zoneinfo for CHAT_TIMEZONE
 
 	"github.com/korjavin/ragtgbot/internal/buffer"
+	"github.com/korjavin/ragtgbot/internal/config"
+	"github.com/korjavin/ragtgbot/internal/embedding"
+	"github.com/korjavin/ragtgbot/internal/outbox"
+	"github.com/korjavin/ragtgbot/internal/pointid"
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+	"github.com/korjavin/ragtgbot/internal/rank"
+	"github.com/korjavin/ragtgbot/internal/resilient"
+	"github.com/korjavin/ragtgbot/internal/schema"
+	"github.com/korjavin/ragtgbot/internal/sparse"
+	"github.com/korjavin/ragtgbot/internal/timerange"
 	tele "gopkg.in/telebot.v3"
 )
 
@@ -44,64 +58,33 @@ type OpenAIChatResponse struct {
 }
 
 const (
-	defaultEmbeddingServiceAddress = "http://localhost:8000/embeddings" // Default address of the embedding service
-	defaultQdrantServiceAddress    = "http://localhost:6333"            // Default address of the Qdrant HTTP API
-	collectionName                 = "chat_history"
-	openaiAPIURL                   = "https://api.openai.com/v1/chat/completions" // OpenAI API URL
-	openaiModel                    = "gpt-4o-mini"                                // OpenAI model to use
-	vectorSearchLimit              = 5                                            // Number of similar messages to retrieve
-	restrictedAccessMessage        = "Sorry, this bot is restricted to answer outside of specific groups, but it's open-source and self-hosted, you can always host your own instance of it at https://github.com/korjavin/ragtgbot"
-	maxChunkSize                   = 3072 // Maximum characters in a buffer before processing
+	sourceLive              = "live" // Payload source of points stored by the bot
+	serviceAttempts         = 4      // Attempts per Qdrant, embedding and OpenAI request
+	mmrPoolFactor           = 3      // Reranked candidates kept for MMR, per final result
+	restrictedAccessMessage = "Sorry, this bot is restricted to answer outside of specific groups, but it's open-source and self-hosted, you can always host your own instance of it at https://github.com/korjavin/ragtgbot"
+	idleFlushCheckInterval  = time.Minute // How often buffers are checked for idle timeout / max age
+	outboxRetryInterval     = time.Minute // How often the outbox is checked for due retries
 )
 
-// Global variables for service addresses
+// Global variables for configuration and services
 var (
-	embeddingServiceAddress string
-	qdrantServiceAddress    string
+	cfg                  *config.Config // Loaded and validated at startup
+	qdrantClient         *qdrant.Client
+	openaiClient         *http.Client
+	embedder             embedding.Embedder
+	embeddingCache       *embedding.Cache // Wraps the embedding backend, counts hits and misses
+	chunkOutbox          *outbox.Outbox   // Failed chunk writes, nil if OUTBOX_DIR is not set
+	keywordSearchEnabled bool             // Whether the collection has the sparse "text" vector
+	reranker             rank.Reranker    // Reorders search candidates, nil to keep search order
 )
 
-type TextList struct {
-	Texts []string `json:"texts"`
-}
-
 // Function to get embeddings from the embedding service
-func getEmbeddings(texts []string) ([]float32, error) {
-	log.Printf("Getting embeddings for %d texts", len(texts))
-
-	jsonData, err := json.Marshal(TextList{Texts: texts})
-	if err != nil {
-		log.Printf("Error marshaling text data: %v", err)
-		return nil, err
-	}
-
-	log.Printf("Sending request to embedding service at %s", embeddingServiceAddress)
-	resp, err := http.Post(embeddingServiceAddress, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		log.Printf("Error connecting to embedding service: %v", err)
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	log.Printf("Received response from embedding service with status: %s", resp.Status)
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return nil, err
-	}
+func getEmbeddings(ctx context.Context, texts []string) ([]float32, error) {
+	log.Printf("Getting embeddings for %d texts from %s", len(texts), embedder.ModelID())
 
-	// The embedding service returns a string containing a JSON array of arrays
-	var embeddingString string
-	err = json.Unmarshal(body, &embeddingString)
+	embeddingList, err := embedder.Embed(ctx, texts)
 	if err != nil {
-		log.Printf("Error unmarshaling embedding string: %v", err)
-		return nil, err
-	}
-
-	// Parse the string as a JSON array of arrays of float32
-	var embeddingList [][]float32
-	err = json.Unmarshal([]byte(embeddingString), &embeddingList)
-	if err != nil {
-		log.Printf("Error unmarshaling embedding list: %v", err)
+		log.Printf("Error getting embeddings: %v", err)
 		return nil, err
 	}
 
@@ -113,263 +96,148 @@ func getEmbeddings(texts []string) ([]float32, error) {
 
 	// Use the first embedding (corresponding to the first text)
 	embeddings := embeddingList[0]
-	log.Printf("Successfully generated embeddings of dimension %d", len(embeddings))
+	log.Printf("Successfully generated embeddings of dimension %d (cache: %s)", len(embeddings), embeddingCache.Stats())
 	return embeddings, nil
 }
 
-// Function to save a message to Qdrant using HTTP API
-func saveToQdrant(messageID int64, text string, username string, embedding []float32) error {
-	log.Printf("Saving message to Qdrant with ID: %d", messageID)
-
-	// Qdrant saving logic using HTTP API
-	qdrantURL := fmt.Sprintf("%s/collections/%s/points", qdrantServiceAddress, collectionName)
-	log.Printf("Using Qdrant URL: %s", qdrantURL)
-
-	// Convert float32 slice to interface{} slice for JSON marshaling
-	embeddingInterface := make([]interface{}, len(embedding))
-	for i, v := range embedding {
-		embeddingInterface[i] = v
-	}
-
-	point := map[string]interface{}{
-		"id": messageID,
-		"vector": map[string]interface{}{
-			"data": embeddingInterface,
-		},
-		"payload": map[string]string{
-			"text":     text,
-			"username": username,
+// Function to build the outbox entry of a chunk: the text to embed and the payload of its point
+func chunkEntry(pointID string, chat buffer.Key, chunk buffer.Chunk) outbox.Entry {
+	return outbox.Entry{
+		ID:   qdrant.PointID(pointID),
+		Text: chunk.Text,
+		Payload: map[string]interface{}{
+			"text":             chunk.Text,
+			"username":         chunk.Username,
+			"chat_id":          strconv.FormatInt(chat.ChatID, 10),
+			"thread_id":        strconv.Itoa(chat.ThreadID),
+			"source":           sourceLive,
+			"first_timestamp":  chunk.FirstDate(),
+			"last_timestamp":   chunk.LastDate(),
+			"first_message_id": chunk.FirstMessageID,
+			"last_message_id":  chunk.LastMessageID,
+			"participants":     chunk.Participants(),
+			"participant_ids":  chunk.ParticipantIDs(),
 		},
 	}
+}
 
-	requestBody, err := json.Marshal(map[string][]map[string]interface{}{
-		"points": {point},
-	})
+// Function to embed a chunk and save it to Qdrant
+func storeEntry(ctx context.Context, entry outbox.Entry) error {
+	embeddings, err := getEmbeddings(ctx, []string{entry.Text})
 	if err != nil {
-		log.Printf("Error marshaling point data: %v", err)
-		return err
+		return fmt.Errorf("error getting embedding: %v", err)
 	}
-
-	req, err := http.NewRequest(http.MethodPut, qdrantURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		log.Printf("Error creating HTTP request: %v", err)
-		return err
+	if err := saveToQdrant(ctx, entry, embeddings); err != nil {
+		return fmt.Errorf("error saving to Qdrant: %v", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
+	return nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error sending HTTP request: %v", err)
-		return err
-	}
-	defer resp.Body.Close()
+// Function to save a message to Qdrant
+func saveToQdrant(ctx context.Context, entry outbox.Entry, embedding []float32) error {
+	log.Printf("Saving message to Qdrant with ID: %s", entry.ID)
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response body: %v", err)
+	// Wait until Qdrant has applied the write, the chunk is dropped from the
+	// WAL as soon as this returns
+	point := entry.Point(embedding, keywordSearchEnabled)
+	if err := qdrantClient.Upsert(ctx, cfg.Qdrant.Collection, []qdrant.Point{point}, true); err != nil {
+		log.Printf("Error saving point to Qdrant: %v", err)
 		return err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error response from Qdrant: %s", string(respBody))
-		return fmt.Errorf("error response from Qdrant: %s", string(respBody))
-	}
-
-	log.Printf("Successfully saved message to Qdrant with ID: %d", messageID)
+	log.Printf("Successfully saved message to Qdrant with ID: %s", entry.ID)
 	return nil
 }
 
-// Function to search for similar messages in Qdrant using HTTP API
-func searchQdrant(embedding []float32, limit int) ([]map[string]interface{}, error) {
-	log.Printf("Searching Qdrant for similar messages with limit: %d", limit)
-
-	// Qdrant search logic using HTTP API
-	qdrantURL := fmt.Sprintf("%s/collections/%s/points/search", qdrantServiceAddress, collectionName)
-	log.Printf("Using Qdrant URL: %s", qdrantURL)
-
-	// Convert float32 slice to interface{} slice for JSON marshaling
-	embeddingInterface := make([]interface{}, len(embedding))
-	for i, v := range embedding {
-		embeddingInterface[i] = v
-	}
-
-	searchRequest := map[string]interface{}{
-		"vector": map[string]interface{}{
-			"name":   "data",
-			"vector": embeddingInterface,
-		},
-		"limit":        limit,
-		"with_payload": true,
-	}
-
-	requestBody, err := json.Marshal(searchRequest)
+// Function to search a single chat with both the dense embedding and the keyword
+// vector of the query, fusing the two rankings with Reciprocal Rank Fusion
+func hybridSearch(ctx context.Context, query string, embedding []float32, limit int, chatID int64, timeRange *timerange.Range) ([]qdrant.ScoredPoint, error) {
+	denseResults, err := searchQdrant(ctx, qdrant.NamedVectorQuery{
+		Name:   "data",
+		Vector: qdrant.DenseVector(embedding),
+	}, limit, chatID, timeRange)
 	if err != nil {
-		log.Printf("Error marshaling search request: %v", err)
 		return nil, err
 	}
 
-	// Log the request body for debugging
-	log.Printf("Search request body: %s", string(requestBody))
-
-	req, err := http.NewRequest(http.MethodPost, qdrantURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		log.Printf("Error creating HTTP request: %v", err)
-		return nil, err
+	queryVector := sparse.EncodeQuery(query)
+	if !keywordSearchEnabled || queryVector.IsEmpty() {
+		return denseResults, nil
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	keywordResults, err := searchQdrant(ctx, qdrant.NamedVectorQuery{
+		Name:   "text",
+		Vector: qdrant.SparseVector(queryVector),
+	}, limit, chatID, timeRange)
 	if err != nil {
-		log.Printf("Error sending HTTP request: %v", err)
-		return nil, err
+		// Dense results alone are still a useful answer
+		log.Printf("Error in keyword search, using dense results only: %v", err)
+		return denseResults, nil
 	}
-	defer resp.Body.Close()
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error response from Qdrant: %s", string(respBody))
-		return nil, fmt.Errorf("error response from Qdrant: %s", string(respBody))
-	}
-
-	// Parse the response
-	var searchResult map[string]interface{}
-	err = json.Unmarshal(respBody, &searchResult)
-	if err != nil {
-		log.Printf("Error unmarshaling search result: %v", err)
-		return nil, err
-	}
-
-	// Extract the result array
-	resultArray, ok := searchResult["result"].([]interface{})
-	if !ok {
-		log.Printf("Error: result field is not an array")
-		return nil, fmt.Errorf("result field is not an array")
-	}
-
-	// Convert to a more usable format
-	results := make([]map[string]interface{}, len(resultArray))
-	for i, r := range resultArray {
-		result, ok := r.(map[string]interface{})
-		if !ok {
-			log.Printf("Error: result item is not a map")
-			return nil, fmt.Errorf("result item is not a map")
-		}
-		results[i] = result
-	}
-
-	log.Printf("Found %d results in Qdrant", len(results))
-	return results, nil
+	fused := rank.FuseRRF([][]qdrant.ScoredPoint{denseResults, keywordResults}, rank.DefaultRRFK, limit)
+	log.Printf("Fused %d dense and %d keyword results into %d", len(denseResults), len(keywordResults), len(fused))
+	return fused, nil
 }
 
-// Function to get collection info
-func getCollectionInfo(collectionName string) (map[string]interface{}, error) {
-	log.Printf("Getting info for collection '%s'...", collectionName)
-
-	// Get collection info
-	qdrantURL := fmt.Sprintf("%s/collections/%s", qdrantServiceAddress, collectionName)
-	resp, err := http.Get(qdrantURL)
-	if err != nil {
-		log.Printf("Error getting collection info: %v", err)
-		return nil, err
-	}
-	defer resp.Body.Close()
+// diversifyResults reports whether search results are diversified with MMR,
+// which needs their dense vectors
+func diversifyResults() bool {
+	return cfg.Search.MMRLambda < 1
+}
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return nil, err
-	}
+// Function to search for similar messages of a single chat in Qdrant
+// The vector is a named dense or sparse query vector.
+// If timeRange is set, only chunks overlapping that span are returned.
+func searchQdrant(ctx context.Context, vector qdrant.NamedVectorQuery, limit int, chatID int64, timeRange *timerange.Range) ([]qdrant.ScoredPoint, error) {
+	log.Printf("Searching Qdrant '%s' vector for similar messages in chat %d with limit: %d", vector.Name, chatID, limit)
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error response from Qdrant: %s", string(respBody))
-		return nil, fmt.Errorf("error response from Qdrant: %s", string(respBody))
+	conditions := []qdrant.Condition{
+		qdrant.FieldMatch("chat_id", strconv.FormatInt(chatID, 10)),
 	}
-
-	// Parse the response
-	var collectionInfo map[string]interface{}
-	err = json.Unmarshal(respBody, &collectionInfo)
-	if err != nil {
-		log.Printf("Error unmarshaling collection info: %v", err)
-		return nil, err
+	if timeRange != nil {
+		// A chunk overlaps the range if it ends after the start and starts before the end
+		log.Printf("Restricting search to %s - %s", timeRange.From, timeRange.To)
+		conditions = append(conditions,
+			qdrant.FieldRange("last_timestamp", qdrant.Range{GTE: qdrant.Bound(float64(timeRange.From.Unix()))}),
+			qdrant.FieldRange("first_timestamp", qdrant.Range{LT: qdrant.Bound(float64(timeRange.To.Unix()))}),
+		)
 	}
 
-	// Log the collection info
-	infoBytes, _ := json.MarshalIndent(collectionInfo, "", "  ")
-	log.Printf("Collection info: %s", string(infoBytes))
-
-	return collectionInfo, nil
-}
-
-// Function to delete a collection
-func deleteQdrantCollection(collectionName string) error {
-	log.Printf("Deleting collection '%s'...", collectionName)
-
-	// Delete collection
-	qdrantURL := fmt.Sprintf("%s/collections/%s", qdrantServiceAddress, collectionName)
-	req, err := http.NewRequest(http.MethodDelete, qdrantURL, nil)
-	if err != nil {
-		log.Printf("Error creating HTTP request: %v", err)
-		return err
+	request := qdrant.SearchRequest{
+		Vector:      vector,
+		Filter:      &qdrant.Filter{Must: conditions},
+		Limit:       limit,
+		WithPayload: true,
 	}
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error sending HTTP request: %v", err)
-		return err
+	if diversifyResults() {
+		request.WithVector = []string{"data"} // Needed for MMR diversification
 	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
+	results, err := qdrantClient.Search(ctx, cfg.Qdrant.Collection, request)
 	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error response from Qdrant: %s", string(respBody))
-		return fmt.Errorf("error response from Qdrant: %s", string(respBody))
+		log.Printf("Error searching Qdrant: %v", err)
+		return nil, err
 	}
 
-	log.Printf("Collection '%s' deleted successfully", collectionName)
-	return nil
+	log.Printf("Found %d results in Qdrant", len(results))
+	return results, nil
 }
 
 // Function to call OpenAI API to generate an answer based on similar messages
-func generateOpenAIAnswer(userQuestion string, similarMessages []map[string]interface{}) (string, error) {
+func generateOpenAIAnswer(userQuestion string, similarMessages []qdrant.ScoredPoint) (string, error) {
 	log.Printf("Generating answer with OpenAI for question: '%s'", userQuestion)
 
-	// Get OpenAI API key from environment
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		log.Printf("Error: OPENAI_API_KEY environment variable is not set")
-		return "", fmt.Errorf("OpenAI API key is not configured")
-	}
-
 	// Format similar messages into snippets
 	var snippets []string
 	for _, result := range similarMessages {
-		payload, ok := result["payload"].(map[string]interface{})
-		if !ok {
-			log.Printf("Warning: payload is not a map, skipping")
-			continue
-		}
-
-		text, ok := payload["text"].(string)
-		if !ok {
-			log.Printf("Warning: text is not a string, skipping")
+		text := result.PayloadString("text")
+		if text == "" {
+			log.Printf("Warning: result %s has no text, skipping", result.ID)
 			continue
 		}
 
-		username, ok := payload["username"].(string)
-		if !ok {
+		username := result.PayloadString("username")
+		if username == "" {
 			username = "Unknown"
 		}
 
@@ -395,7 +263,7 @@ func generateOpenAIAnswer(userQuestion string, similarMessages []map[string]inte
 	}
 
 	requestBody := OpenAIChatRequest{
-		Model:    openaiModel,
+		Model:    cfg.OpenAI.Model,
 		Messages: messages,
 	}
 
@@ -406,19 +274,18 @@ func generateOpenAIAnswer(userQuestion string, similarMessages []map[string]inte
 	}
 
 	// Create the HTTP request
-	req, err := http.NewRequest(http.MethodPost, openaiAPIURL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest(http.MethodPost, cfg.OpenAI.URL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		log.Printf("Error creating OpenAI HTTP request: %v", err)
 		return "", err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Authorization", "Bearer "+cfg.OpenAI.APIKey)
 
 	// Send the request
 	log.Printf("Sending request to OpenAI API...")
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := openaiClient.Do(req)
 	if err != nil {
 		log.Printf("Error sending request to OpenAI: %v", err)
 		return "", err
@@ -458,118 +325,105 @@ func generateOpenAIAnswer(userQuestion string, similarMessages []map[string]inte
 	return answer, nil
 }
 
-// Function to check if a collection exists and create it if it doesn't
-func createQdrantCollection(collectionName string) error {
-	log.Printf("Checking if collection '%s' exists...", collectionName)
+// Function to check the collection schema and create the collection if it doesn't exist
+// An incompatible collection is never deleted, the bot refuses to start instead.
+func createQdrantCollection(ctx context.Context, collectionName string, model embedding.Embedder) error {
+	log.Printf("Checking collection '%s'...", collectionName)
 
-	// Check if collection exists
-	qdrantURL := fmt.Sprintf("%s/collections/%s", qdrantServiceAddress, collectionName)
-	resp, err := http.Get(qdrantURL)
+	status, err := schema.Ensure(ctx, qdrantClient, collectionName, model.Dimension())
+	var dimErr *schema.DimensionError
+	if errors.As(err, &dimErr) {
+		log.Printf("Embedding model '%s' does not match collection '%s': %v", model.ModelID(), collectionName, err)
+		return fmt.Errorf("embedding model '%s' produces %d-dimensional vectors, collection '%s' needs %d: "+
+			"switch back to the model the collection was built with or re-embed it", model.ModelID(), dimErr.Want, collectionName, dimErr.Have)
+	}
 	if err != nil {
-		log.Printf("Error checking if collection exists: %v", err)
+		log.Printf("Error checking collection: %v", err)
 		return err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusOK {
-		log.Printf("Collection '%s' already exists", collectionName)
+	log.Printf("Using collection '%s' via '%s' (schema version %d, dimension %d)",
+		status.Collection, collectionName, status.Version, status.Dimension)
+	if !status.Aliased || status.Version < schema.CurrentVersion {
+		log.Printf("Collection '%s' is not at schema version %d, run the migrate command to upgrade it", collectionName, schema.CurrentVersion)
+	}
 
-		// Get collection info
-		collectionInfo, err := getCollectionInfo(collectionName)
-		if err != nil {
-			log.Printf("Error getting collection info: %v", err)
-		}
+	// Collections created before hybrid search have no sparse vector
+	keywordSearchEnabled = status.KeywordSearch()
+	if !keywordSearchEnabled {
+		log.Printf("Sparse vector 'text' is not configured in this collection, keyword search disabled")
+	}
+	return nil
+}
 
-		// Check if the collection has vectors configured
-		result, ok := collectionInfo["result"].(map[string]interface{})
-		if !ok {
-			log.Printf("Error: result field is not a map")
-		} else {
-			config, ok := result["config"].(map[string]interface{})
-			if !ok {
-				log.Printf("Error: config field is not a map")
-			} else {
-				params, ok := config["params"].(map[string]interface{})
-				if !ok {
-					log.Printf("Error: params field is not a map")
-				} else {
-					vectors, ok := params["vectors"].(map[string]interface{})
-					if !ok || len(vectors) == 0 {
-						log.Printf("Vectors are not configured in this collection, recreating...")
-
-						// Delete the collection
-						err = deleteQdrantCollection(collectionName)
-						if err != nil {
-							log.Printf("Error deleting collection: %v", err)
-							return err
-						}
-					} else {
-						log.Printf("Vectors configuration: %v", vectors)
-
-						// Check if the vectors configuration has a "data" field
-						_, hasDataVector := vectors["data"]
-						if !hasDataVector {
-							log.Printf("Vector with name 'data' is not configured in this collection, recreating...")
-
-							// Delete the collection
-							err = deleteQdrantCollection(collectionName)
-							if err != nil {
-								log.Printf("Error deleting collection: %v", err)
-								return err
-							}
-						} else {
-							return nil
-						}
-					}
-				}
+// Function to create the reranker selected by search.reranker ("cross-encoder", "lexical" or "none")
+func newReranker(search config.Search, embeddingConfig config.Embedding) rank.Reranker {
+	switch search.Reranker {
+	case config.RerankerNone:
+		log.Println("Reranking disabled")
+		return nil
+	case config.RerankerLexical:
+		log.Println("Using lexical-overlap reranker")
+		return rank.LexicalReranker{}
+	default: // Validated by config.Load
+		serviceAddress := search.RerankAddress
+		if serviceAddress == "" {
+			// The rerank endpoint lives next to the embeddings endpoint of the embedding service
+			embeddingServiceAddress := embedding.DefaultServiceURL
+			if embeddingConfig.Backend == embedding.BackendService && embeddingConfig.Address != "" {
+				embeddingServiceAddress = embeddingConfig.Address
 			}
+			serviceAddress = strings.TrimSuffix(embeddingServiceAddress, "/embeddings") + "/rerank"
 		}
-	}
-
-	log.Printf("Collection '%s' does not exist, creating...", collectionName)
-
-	// Create collection
-	requestBody, err := json.Marshal(map[string]interface{}{
-		"vectors": map[string]interface{}{
-			"data": map[string]interface{}{
-				"size":     512, // Embedding size
-				"distance": "Cosine",
+		log.Printf("Using cross-encoder reranker at %s with lexical fallback", serviceAddress)
+		return rank.FallbackReranker{
+			Primary: rank.CrossEncoderReranker{
+				URL:    serviceAddress,
+				Client: newServiceClient("rerank", search.RerankTimeout, 1), // Falls back to lexical instead of retrying
 			},
-		},
-	})
-	if err != nil {
-		log.Printf("Error marshaling collection creation request: %v", err)
-		return err
+			Fallback: rank.LexicalReranker{},
+			OnError: func(err error) {
+				log.Printf("Error from cross-encoder reranker, falling back to lexical: %v", err)
+			},
+		}
 	}
+}
 
-	req, err := http.NewRequest(http.MethodPut, qdrantURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		log.Printf("Error creating HTTP request: %v", err)
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
+// Function to create the HTTP client of an outbound service: each attempt is bounded by
+// timeout, failures are retried up to attempts times and a circuit breaker, whose state
+// changes are logged, stops calling a service that keeps failing
+func newServiceClient(name string, timeout time.Duration, attempts int) *http.Client {
+	policy := resilient.DefaultPolicy(timeout)
+	policy.MaxAttempts = attempts
+	return resilient.NewLoggingClient(name, policy)
+}
 
-	client := &http.Client{}
-	resp, err = client.Do(req)
-	if err != nil {
-		log.Printf("Error sending HTTP request: %v", err)
-		return err
+// Periodically flush buffers of chats that went quiet or held messages for too long
+func runIdleFlusher(ctx context.Context, chatBuffers *buffer.Registry, idleTimeout, maxAge time.Duration) {
+	if idleTimeout == 0 && maxAge == 0 {
+		log.Println("Idle flushing disabled")
+		return
 	}
-	defer resp.Body.Close()
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return err
-	}
+	ticker := time.NewTicker(idleFlushCheckInterval)
+	defer ticker.Stop()
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error response from Qdrant: %s", string(respBody))
-		return fmt.Errorf("error response from Qdrant: %s", string(respBody))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, chatKey := range chatBuffers.Keys() {
+				msgBuffer := chatBuffers.Get(chatKey)
+				if !msgBuffer.Expired(now, idleTimeout, maxAge) {
+					continue
+				}
+				log.Printf("Buffer of chat %s is idle or too old, processing...", chatKey)
+				if err := processBuffer(ctx, chatKey, chatBuffers); err != nil {
+					log.Printf("Error processing idle buffer of chat %s: %v", chatKey, err)
+				}
+			}
+		}
 	}
-
-	log.Printf("Collection '%s' created successfully", collectionName)
-	return nil
 }
 
 // Function to check if a chat is allowed
@@ -587,86 +441,153 @@ func isAllowedChat(chatID int64, allowedGroups []int64) bool {
 	return false
 }
 
-// Process message buffer and save to Qdrant
-func processBuffer(buffer *buffer.MessageBuffer) error {
-	if buffer.IsEmpty() {
+// Process a chat's message buffer and save to Qdrant. With an outbox a chunk that
+// cannot be stored is queued there for retrying instead of being lost.
+func processBuffer(ctx context.Context, chat buffer.Key, chatBuffers *buffer.Registry) error {
+	chunk := chatBuffers.Take(chat)
+	if chunk.Size == 0 {
 		return nil // Nothing to process
 	}
-
-	text, username, size := buffer.GetContents()
-	log.Printf("Processing message buffer with %d characters", size)
-
-	// Get embedding for combined text
-	embeddings, err := getEmbeddings([]string{text})
-	if err != nil {
-		return fmt.Errorf("error getting embedding: %v", err)
+	log.Printf("Processing message buffer for chat %s with %d characters", chat, chunk.Size)
+
+	// The ID only depends on chat and message range so a chunk replayed after
+	// a restart overwrites instead of duplicating
+	id := pointid.ForChunk(chat.ChatID, chunk.FirstMessageID, chunk.LastMessageID)
+	entry := chunkEntry(id, chat, chunk)
+	if err := storeEntry(ctx, entry); err != nil {
+		// Without the outbox the messages go back into the buffer and are
+		// stored with the next chunk of the chat
+		if chunkOutbox == nil {
+			chatBuffers.Restore(chat, chunk)
+			return err
+		}
+		if queueErr := chunkOutbox.Add(entry, err); queueErr != nil {
+			chatBuffers.Restore(chat, chunk)
+			return fmt.Errorf("%v, and queueing it in the outbox failed: %v", err, queueErr)
+		}
+		log.Printf("Could not store buffer of chat %s, queued it in the outbox for retrying: %v", chat, err)
+	} else {
+		log.Printf("Successfully processed buffer for chat %s and saved to Qdrant with ID: %s", chat, id)
 	}
 
-	// Save to Qdrant
-	id := time.Now().UnixNano()
-	// Ensure we save the raw text, assuming 'text' from GetContents is raw
-	err = saveToQdrant(id, text, username, embeddings) // Assuming 'text' is raw message content
-	if err != nil {
-		return fmt.Errorf("error saving to Qdrant: %v", err)
+	// The chunk is stored or queued, its messages no longer need to be replayed
+	if err := chatBuffers.Commit(chat, chunk); err != nil {
+		log.Printf("Error removing stored messages of chat %s from the WAL: %v", chat, err)
 	}
-
-	log.Printf("Successfully processed buffer and saved to Qdrant with ID: %d", id)
 	return nil
 }
 
+// Periodically retry the chunks in the outbox whose next attempt is due
+func runOutboxRetrier(ctx context.Context, box *outbox.Outbox) {
+	ticker := time.NewTicker(outboxRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := box.Flush(ctx, storeEntry)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Error updating the outbox: %v", err)
+			}
+			if result != (outbox.FlushResult{}) {
+				log.Printf("Outbox retry: %d stored, %d failed again, %d moved to the dead letters, %d pending",
+					result.Stored, result.Retrying, result.Dead, box.Len())
+			}
+		}
+	}
+}
+
 func main() {
+	configPath := flag.String("config", os.Getenv(config.FileEnv), "YAML or TOML config file, environment variables override it")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
+	flag.Parse()
+
 	log.Println("Starting Telegram RAG bot...")
 
-	// Telegram Bot Token
-	token := os.Getenv("TELEGRAM_BOT_TOKEN")
-	if token == "" {
-		log.Fatal("TELEGRAM_BOT_TOKEN environment variable is required")
+	// Every setting is read and checked up front, a mistake stops the bot before it connects anywhere
+	var err error
+	cfg, err = config.Load(*configPath)
+	if err == nil {
+		err = cfg.ValidateBot()
 	}
-	log.Println("Telegram token found")
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	if *configPath != "" {
+		log.Printf("Using config file: %s", *configPath)
+	}
+	log.Printf("Effective configuration:\n%s", cfg.Redacted())
+	if *printConfig {
+		return
+	}
+
+	qdrantClient = qdrant.NewClient(cfg.Qdrant.Address, newServiceClient("qdrant", cfg.Qdrant.Timeout, serviceAttempts))
+	openaiClient = newServiceClient("openai", cfg.OpenAI.Timeout, serviceAttempts)
 
-	// Check for OpenAI API key
-	openaiKey := os.Getenv("OPENAI_API_KEY")
-	if openaiKey == "" {
-		log.Fatal("OPENAI_API_KEY environment variable is required")
+	// Circuit breaker states and request counters of the services above, and the
+	// embedding cache counters, are served as expvars at /debug/vars
+	if metricsAddress := cfg.Metrics.Address; metricsAddress != "" {
+		log.Printf("Serving metrics at http://%s/debug/vars", metricsAddress)
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/debug/vars", expvar.Handler())
+			if err := http.ListenAndServe(metricsAddress, mux); err != nil {
+				log.Printf("Error serving metrics: %v", err)
+			}
+		}()
 	}
-	log.Println("OpenAI API key found")
 
-	// Set service addresses from environment variables or use defaults
-	embeddingServiceAddress = os.Getenv("EMBEDDING_SERVICE_ADDRESS")
-	if embeddingServiceAddress == "" {
-		embeddingServiceAddress = defaultEmbeddingServiceAddress
-		log.Printf("EMBEDDING_SERVICE_ADDRESS not set, using default: %s", embeddingServiceAddress)
+	allowedGroups := cfg.Telegram.Groups
+	if len(allowedGroups) > 0 {
+		log.Printf("Restricted to %d groups", len(allowedGroups))
 	} else {
-		log.Printf("Using embedding service at: %s", embeddingServiceAddress)
+		log.Println("No group restrictions set, bot will respond in all chats")
 	}
 
-	qdrantServiceAddress = os.Getenv("QDRANT_SERVICE_ADDRESS")
-	if qdrantServiceAddress == "" {
-		qdrantServiceAddress = defaultQdrantServiceAddress
-		log.Printf("QDRANT_SERVICE_ADDRESS not set, using default: %s", qdrantServiceAddress)
-	} else {
-		log.Printf("Using Qdrant service at: %s", qdrantServiceAddress)
+	// Configure the reranker for search candidates
+	reranker = newReranker(cfg.Search, cfg.Embedding)
+
+	// Dates in questions ("yesterday") are interpreted in the chat's time zone
+	chatLocation, _ := cfg.Telegram.Location() // Validated by config.Load
+
+	// The collection is created for, and checked against, the embedding model's dimension
+	embeddingConfig := cfg.Embedding.EmbedderConfig(newServiceClient("embedding", cfg.Embedding.Timeout, serviceAttempts))
+	embedder, err = embedding.New(context.Background(), embeddingConfig)
+	if err != nil {
+		log.Fatalf("Failed to set up embedding backend: %v", err)
 	}
+	log.Printf("Using embedding model '%s' with dimension %d", embedder.ModelID(), embedder.Dimension())
 
-	// Parse allowed groups
-	var allowedGroups []int64
-	if groupsList := os.Getenv("TG_GROUP_LIST"); groupsList != "" {
-		groups := strings.Split(groupsList, ",")
-		for _, group := range groups {
-			if groupID, err := strconv.ParseInt(strings.TrimSpace(group), 10, 64); err == nil {
-				allowedGroups = append(allowedGroups, groupID)
-				log.Printf("Added allowed group ID: %d", groupID)
-			} else {
-				log.Printf("Warning: invalid group ID in TG_GROUP_LIST: %s", group)
-			}
-		}
-		log.Printf("Restricted to %d groups", len(allowedGroups))
+	// Repeated questions and re-flushed chunks are answered from the cache.
+	// Only one process at a time gets the disk tier, so while an import
+	// holds the file the bot caches in memory only.
+	cacheConfig := cfg.Embedding.CacheConfig()
+	embeddingCache, err = embedding.NewCache(embedder, cacheConfig)
+	if errors.Is(err, embedding.ErrCacheLocked) {
+		log.Printf("Warning: %v, caching in memory only", err)
+		cacheConfig.Path = ""
+		embeddingCache, err = embedding.NewCache(embedder, cacheConfig)
+	}
+	if err != nil {
+		log.Fatalf("Failed to open embedding cache: %v", err)
+	}
+	defer func() {
+		log.Printf("Embedding cache: %s", embeddingCache.Stats())
+		embeddingCache.Close()
+	}()
+	if cacheConfig.Path != "" {
+		log.Printf("Caching up to %d embeddings in memory and all of them in %s", cacheConfig.Size, cacheConfig.Path)
 	} else {
-		log.Println("No group restrictions set, bot will respond in all chats")
+		log.Printf("Caching up to %d embeddings in memory, embedding.cache_path not set", cacheConfig.Size)
 	}
+	embedder = embeddingCache
+	expvar.Publish("embedding_cache", expvar.Func(func() any { return embeddingCache.Stats() }))
 
-	// Create Qdrant collection if it doesn't exist
-	err := createQdrantCollection(collectionName)
+	// Create Qdrant collection if it doesn't exist, along with the payload indexes
+	// used for filtering: searches are always filtered by chat and sometimes by time
+	err = createQdrantCollection(context.Background(), cfg.Qdrant.Collection, embedder)
 	if err != nil {
 		log.Fatalf("Failed to create/check Qdrant collection: %v", err)
 	}
@@ -674,7 +595,7 @@ func main() {
 	// Telebot settings
 	log.Println("Configuring Telegram bot...")
 	pref := tele.Settings{
-		Token:  token,
+		Token:  cfg.Telegram.Token,
 		Poller: &tele.LongPoller{Timeout: 10 * time.Second},
 	}
 
@@ -693,8 +614,38 @@ func main() {
 	defer cancel()
 	log.Println("Graceful shutdown configured")
 
-	// Initialize message buffer
-	msgBuffer := buffer.NewMessageBuffer()
+	// Initialize per-chat message buffers, backed by a WAL if configured
+	chatBuffers := buffer.NewRegistry()
+	if walPath := cfg.Buffer.WALPath; walPath != "" {
+		log.Printf("Using buffer WAL at: %s", walPath)
+		chatBuffers, err = buffer.NewRegistryWithWAL(walPath)
+		if err != nil {
+			log.Fatalf("Failed to open buffer WAL: %v", err)
+		}
+		for _, chatKey := range chatBuffers.Keys() {
+			_, _, size := chatBuffers.Get(chatKey).GetContents()
+			log.Printf("Replayed %d characters of unsaved messages for chat %s from the WAL", size, chatKey)
+		}
+	} else {
+		log.Println("buffer.wal_path not set, buffered messages are kept in memory only")
+	}
+
+	// Chunks that cannot be stored are kept in the outbox and retried
+	if outboxDir := cfg.Outbox.Dir; outboxDir != "" {
+		maxAttempts := cfg.Outbox.MaxAttempts
+		chunkOutbox, err = outbox.Open(outboxDir, maxAttempts)
+		if errors.Is(err, outbox.ErrLocked) {
+			// Imports use a directory of their own, this is another bot or a replay
+			log.Fatalf("Failed to open outbox: %v, stop the other bot or outbox replay first", err)
+		} else if err != nil {
+			log.Fatalf("Failed to open outbox: %v", err)
+		}
+		defer chunkOutbox.Close()
+		log.Printf("Using outbox at %s with %d pending chunks, giving up after %d attempts", outboxDir, chunkOutbox.Len(), maxAttempts)
+	} else {
+		log.Println("outbox.dir not set, chunks that cannot be stored stay in memory until a later attempt succeeds")
+	}
+	defer chatBuffers.Close()
 
 	// Message handler
 	log.Println("Setting up message handler...")
@@ -710,6 +661,10 @@ func main() {
 			return nil
 		}
 
+		// Each chat (and forum topic) gets its own buffer
+		chatKey := buffer.Key{ChatID: c.Chat().ID, ThreadID: c.Message().ThreadID}
+		msgBuffer := chatBuffers.Get(chatKey)
+
 		// Check if the bot is mentioned
 		if strings.Contains(c.Text(), "@"+b.Me.Username) {
 			log.Println("Bot was mentioned, processing as a query...")
@@ -718,17 +673,16 @@ func main() {
 			query = strings.TrimSpace(query)
 			log.Printf("Extracted query: '%s'", query)
 
-			// Process any buffered messages before handling the query
+			// Process any buffered messages of this chat before handling the query
 			if !msgBuffer.IsEmpty() {
-				if err := processBuffer(msgBuffer); err != nil {
+				if err := processBuffer(ctx, chatKey, chatBuffers); err != nil {
 					log.Printf("Error processing buffered messages: %v", err)
 				}
-				msgBuffer.Clear()
 			}
 
 			// Get embedding for the query
 			log.Println("Getting embeddings for query...")
-			queryEmbeddings, err := getEmbeddings([]string{query})
+			queryEmbeddings, err := getEmbeddings(ctx, []string{query})
 			if err != nil {
 				log.Printf("Error getting embedding for query: %v", err)
 				return c.Send("Error processing your query")
@@ -737,13 +691,55 @@ func main() {
 
 			// Search the vector database for top similar messages
 			log.Println("Searching vector database for similar messages...")
-			searchResults, err := searchQdrant(queryEmbeddings, vectorSearchLimit)
+			var timeRange *timerange.Range
+			if tr, ok := timerange.Parse(query, time.Now(), chatLocation); ok {
+				log.Printf("Query refers to %s - %s", tr.From, tr.To)
+				timeRange = &tr
+			}
+			diversify := diversifyResults()
+			searchLimit := cfg.Search.Limit
+			if reranker != nil || diversify {
+				searchLimit = cfg.Search.CandidateLimit // Over-fetch, reranking and MMR pick the best
+			}
+			searchResults, err := hybridSearch(ctx, query, queryEmbeddings, searchLimit, chatKey.ChatID, timeRange)
+			if err == nil && len(searchResults) == 0 && timeRange != nil {
+				log.Println("Nothing found in the requested time range, searching the whole history...")
+				searchResults, err = hybridSearch(ctx, query, queryEmbeddings, searchLimit, chatKey.ChatID, nil)
+			}
 			if err != nil {
 				log.Printf("Error searching vector database: %v", err)
 				return c.Send("Error processing your query")
 			}
 			log.Printf("Found %d results in vector database", len(searchResults))
 
+			if reranker != nil && len(searchResults) > 0 {
+				// With MMR enabled keep a larger pool for it to choose from
+				rerankLimit := cfg.Search.Limit
+				if diversify {
+					rerankLimit = cfg.Search.Limit * mmrPoolFactor
+				}
+				rerankCtx, cancelRerank := context.WithTimeout(ctx, cfg.Search.RerankTimeout)
+				reranked, err := rank.Rerank(rerankCtx, reranker, query, searchResults, rerankLimit)
+				cancelRerank()
+				if err != nil {
+					log.Printf("Error reranking results, keeping search order: %v", err)
+					if len(searchResults) > rerankLimit {
+						searchResults = searchResults[:rerankLimit]
+					}
+				} else {
+					log.Printf("Reranked %d candidates down to %d", len(searchResults), len(reranked))
+					searchResults = reranked
+				}
+			}
+
+			if diversify {
+				// Spread the small context over distinct discussions
+				searchResults = rank.MMR(searchResults, "data", cfg.Search.MMRLambda, cfg.Search.Limit)
+				log.Printf("Selected %d diverse results with MMR (lambda %.2f)", len(searchResults), cfg.Search.MMRLambda)
+			} else if len(searchResults) > cfg.Search.Limit {
+				searchResults = searchResults[:cfg.Search.Limit]
+			}
+
 			// Generate answer using OpenAI
 			log.Println("Generating answer using OpenAI...")
 			aiAnswer, err := generateOpenAIAnswer(query, searchResults)
@@ -774,20 +770,14 @@ func main() {
 					break
 				}
 
-				payload, ok := result["payload"].(map[string]interface{})
-				if !ok {
-					log.Printf("Error: payload is not a map")
-					continue
-				}
-
-				text, ok := payload["text"].(string)
-				if !ok {
-					log.Printf("Error: text is not a string")
+				text := result.PayloadString("text")
+				if text == "" {
+					log.Printf("Error: result %s has no text", result.ID)
 					continue
 				}
 
-				username, ok := payload["username"].(string)
-				if !ok {
+				username := result.PayloadString("username")
+				if username == "" {
 					username = "Unknown"
 				}
 
@@ -839,18 +829,29 @@ func main() {
 		}
 
 		// Add message to buffer
-		log.Println("Adding message to buffer...")
-		msgBuffer.Add(c.Sender().Username, c.Text())
+		log.Printf("Adding message to buffer of chat %s...", chatKey)
+		msg := buffer.Message{
+			ID:       int64(c.Message().ID),
+			Username: c.Sender().Username,
+			FromID:   fmt.Sprintf("user%d", c.Sender().ID), // Same form as Telegram exports
+			Text:     c.Text(),
+			Date:     c.Message().Unixtime,
+		}
+		if c.Message().ReplyTo != nil {
+			msg.ReplyToID = int64(c.Message().ReplyTo.ID)
+		}
+		if err := chatBuffers.Add(chatKey, msg); err != nil {
+			log.Printf("Error writing message of chat %s to the WAL, keeping it in memory only: %v", chatKey, err)
+		}
 
 		// Process buffer if it exceeds max size
 		_, _, size := msgBuffer.GetContents()
-		if size >= maxChunkSize {
-			log.Println("Buffer size exceeded maximum, processing...")
-			if err := processBuffer(msgBuffer); err != nil {
+		if size >= cfg.Chunking.MaxSize {
+			log.Printf("Buffer size of chat %s exceeded maximum, processing...", chatKey)
+			if err := processBuffer(ctx, chatKey, chatBuffers); err != nil {
 				log.Printf("Error processing buffer: %v", err)
 				// Don't return an error to the user for background processing
 			}
-			msgBuffer.Clear()
 		}
 
 		return nil
@@ -864,16 +865,34 @@ func main() {
 		b.Start()
 	}()
 
+	// Start the idle flusher
+	flusherDone := make(chan struct{})
+	go func() {
+		defer close(flusherDone)
+		runIdleFlusher(ctx, chatBuffers, cfg.Buffer.IdleTimeout, cfg.Buffer.MaxAge)
+	}()
+
+	// Retry failed chunk writes in the background
+	if chunkOutbox != nil {
+		go runOutboxRetrier(ctx, chunkOutbox)
+	}
+
 	log.Println("Bot is running in the background. Press Ctrl+C to stop.")
 
 	// Wait for shutdown signal
 	<-ctx.Done()
+	<-flusherDone
 
-	// Process any remaining buffered messages before shutdown
-	if !msgBuffer.IsEmpty() {
-		log.Println("Processing remaining buffered messages before shutdown...")
-		if err := processBuffer(msgBuffer); err != nil {
-			log.Printf("Error processing final buffer: %v", err)
+	// Process any remaining buffered messages of every chat before shutdown
+	for _, chatKey := range chatBuffers.Keys() {
+		msgBuffer := chatBuffers.Get(chatKey)
+		if msgBuffer.IsEmpty() {
+			continue
+		}
+		log.Printf("Processing remaining buffered messages of chat %s before shutdown...", chatKey)
+		// The shutdown context is already cancelled, the final writes get their own
+		if err := processBuffer(context.Background(), chatKey, chatBuffers); err != nil {
+			log.Printf("Error processing final buffer of chat %s: %v", chatKey, err)
 		}
 	}
 
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// listFlag collects the values of a flag given more than once
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// chatFilter selects the chats of an export to import. An empty filter
+// selects every chat.
+type chatFilter struct {
+	chats []string // Chat IDs, as in the export or as the bot sees them, or names
+	types []string // Chat types, e.g. private_supergroup
+}
+
+// Match reports whether a chat passes the filter: its type is one of the
+// types, and its ID or name one of the chats, if any are given
+func (f chatFilter) Match(chat *TelegramBackup) bool {
+	if len(f.types) > 0 && !containsFold(f.types, chat.Type) {
+		return false
+	}
+	if len(f.chats) == 0 {
+		return true
+	}
+	for _, value := range f.chats {
+		if id, err := strconv.ParseInt(value, 10, 64); err == nil && (id == chat.ID || id == chat.BotChatID()) {
+			return true
+		}
+	}
+	return containsFold(f.chats, chat.Name)
+}
+
+func containsFold(values []string, s string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// checkpointFile is the on-disk form of a checkpoint
+type checkpointFile struct {
+	Collection string          `json:"collection"`
+	Chats      map[int64]int64 `json:"chats"` // Bot chat ID -> last persisted message ID
+}
+
+// Checkpoint records, per chat, the message ID up to which an import has
+// been persisted: every chunk ending at or before it is stored in Qdrant or
+// queued in the outbox. A resumed import skips those messages; as chunking
+// starts afresh after a chunk boundary, it produces the same points.
+type Checkpoint struct {
+	path  string
+	mutex sync.Mutex
+	file  checkpointFile
+}
+
+// NewCheckpoint starts an empty checkpoint for an import from scratch. The
+// file at path is replaced once the first chunk is persisted.
+func NewCheckpoint(path, collection string) *Checkpoint {
+	return &Checkpoint{
+		path: path,
+		file: checkpointFile{Collection: collection, Chats: make(map[int64]int64)},
+	}
+}
+
+// OpenCheckpoint reads the checkpoint at path to resume an import, or
+// starts an empty one if the file does not exist yet. A checkpoint written
+// for another collection is refused, its messages are not in this one.
+func OpenCheckpoint(path, collection string) (*Checkpoint, error) {
+	checkpoint := NewCheckpoint(path, collection)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint: %v", err)
+	}
+	var file checkpointFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing checkpoint %s: %v", path, err)
+	}
+	if file.Collection != collection {
+		return nil, fmt.Errorf("checkpoint %s was written for collection '%s', not '%s'", path, file.Collection, collection)
+	}
+	if file.Chats != nil {
+		checkpoint.file.Chats = file.Chats
+	}
+	return checkpoint, nil
+}
+
+// Last returns the last persisted message ID of a chat, 0 if there is none
+func (c *Checkpoint) Last(chatID int64) int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.file.Chats[chatID]
+}
+
+// Track follows the chunks of one chat through the pipeline, moving the
+// checkpoint forward from last as they are persisted
+func (c *Checkpoint) Track(chatID, last int64) *ChatProgress {
+	return &ChatProgress{checkpoint: c, chatID: chatID, last: last, persisted: make(map[int64]bool)}
+}
+
+func (c *Checkpoint) set(chatID, last int64) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.file.Chats[chatID] = last
+	return c.save()
+}
+
+// save atomically replaces the checkpoint file
+func (c *Checkpoint) save() error {
+	data, err := json.MarshalIndent(c.file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file for %s: %v", c.path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing %s: %v", tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error syncing %s: %v", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("error replacing %s: %v", c.path, err)
+	}
+	return nil
+}
+
+// ChatProgress moves a chat's checkpoint forward. Chunks finish out of
+// order, so it only advances over an unbroken run of persisted chunks,
+// in the order they were submitted. Exports list messages by ascending ID.
+type ChatProgress struct {
+	checkpoint *Checkpoint
+	chatID     int64
+
+	mutex     sync.Mutex
+	last      int64          // Message ID the checkpoint is at
+	submitted []int64        // Last message IDs of chunks not yet covered by the checkpoint
+	persisted map[int64]bool // Those of them that have been persisted
+}
+
+// Submitted registers a chunk, by its last message ID, as it enters the pipeline
+func (p *ChatProgress) Submitted(lastMessageID int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.submitted = append(p.submitted, lastMessageID)
+}
+
+// Persisted marks chunks stored or queued in the outbox and saves the
+// checkpoint if it moved
+func (p *ChatProgress) Persisted(lastMessageIDs ...int64) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, id := range lastMessageIDs {
+		p.persisted[id] = true
+	}
+
+	advanced := false
+	for len(p.submitted) > 0 && p.persisted[p.submitted[0]] {
+		delete(p.persisted, p.submitted[0])
+		p.last = p.submitted[0]
+		p.submitted = p.submitted[1:]
+		advanced = true
+	}
+	if !advanced {
+		return nil
+	}
+	return p.checkpoint.set(p.chatID, p.last)
+}
+
+// Last returns the message ID the checkpoint is at
+func (p *ChatProgress) Last() int64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.last
+}
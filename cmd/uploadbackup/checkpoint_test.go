@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/korjavin/ragtgbot/internal/buffer"
+	"github.com/korjavin/ragtgbot/internal/outbox"
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointAdvancesInOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "result.json.checkpoint")
+	progress := NewCheckpoint(path, "chat_history").Track(-100, 0)
+
+	for _, id := range []int64{3, 7, 12} {
+		progress.Submitted(id)
+	}
+
+	// A later chunk finishing first does not move the checkpoint past an earlier one
+	require.NoError(t, progress.Persisted(7))
+	assert.Equal(t, int64(0), progress.Last())
+
+	require.NoError(t, progress.Persisted(3))
+	assert.Equal(t, int64(7), progress.Last())
+
+	require.NoError(t, progress.Persisted(12))
+	assert.Equal(t, int64(12), progress.Last())
+
+	// The file holds the checkpoint for a resumed import
+	reopened, err := OpenCheckpoint(path, "chat_history")
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), reopened.Last(-100))
+	assert.Equal(t, int64(0), reopened.Last(-200))
+}
+
+func TestCheckpointKeepsOtherChats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	checkpoint := NewCheckpoint(path, "chat_history")
+
+	first := checkpoint.Track(-100, 0)
+	first.Submitted(5)
+	require.NoError(t, first.Persisted(5))
+
+	second := checkpoint.Track(-200, 40)
+	second.Submitted(50)
+	require.NoError(t, second.Persisted(50))
+
+	reopened, err := OpenCheckpoint(path, "chat_history")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), reopened.Last(-100))
+	assert.Equal(t, int64(50), reopened.Last(-200))
+}
+
+func TestOpenCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+
+	// Nothing to resume yet
+	checkpoint, err := OpenCheckpoint(filepath.Join(dir, "missing"), "chat_history")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), checkpoint.Last(-100))
+
+	path := filepath.Join(dir, "checkpoint")
+	progress := NewCheckpoint(path, "chat_history").Track(-100, 0)
+	progress.Submitted(5)
+	require.NoError(t, progress.Persisted(5))
+
+	_, err = OpenCheckpoint(path, "other_collection")
+	assert.Error(t, err)
+}
+
+func TestPipelineCheckpointStopsAtFailure(t *testing.T) {
+	// Storing the second chunk fails; without an outbox it is lost
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Points []struct {
+				Payload map[string]interface{} `json:"payload"`
+			} `json:"points"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		for _, point := range req.Points {
+			if point.Payload["last_message_id"] == float64(2) {
+				http.Error(w, "disk full", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer store.Close()
+
+	progress := NewCheckpoint(filepath.Join(t.TempDir(), "checkpoint"), "chat_history").Track(-100, 0)
+	p := newPipeline(qdrant.NewClient(store.URL, nil), &fakeEmbedder{}, nil, progress, -100, 2, 1, 1, pb.New(3))
+	for i := int64(1); i <= 3; i++ {
+		p.Submit(buffer.Chunk{Text: "text", FirstMessageID: i, LastMessageID: i, Messages: []buffer.Message{{ID: i}}})
+	}
+	p.Close()
+
+	assert.Equal(t, int64(2), p.stored.Load())
+	assert.Equal(t, int64(1), p.failed.Load())
+	assert.Equal(t, int64(1), progress.Last())
+}
+
+func TestPipelineCheckpointPassesQueuedChunks(t *testing.T) {
+	box, err := outbox.Open(t.TempDir(), outbox.DefaultMaxAttempts)
+	require.NoError(t, err)
+	progress := NewCheckpoint(filepath.Join(t.TempDir(), "checkpoint"), "chat_history").Track(-100, 0)
+
+	p := newPipeline(qdrant.NewClient("http://127.0.0.1:0", nil), &fakeEmbedder{err: errors.New("model not loaded")}, box, progress, -100, 1, 2, 2, pb.New(2))
+	p.Submit(buffer.Chunk{Text: "a", FirstMessageID: 1, LastMessageID: 1, Messages: []buffer.Message{{ID: 1}}})
+	p.Submit(buffer.Chunk{Text: "b", FirstMessageID: 2, LastMessageID: 2, Messages: []buffer.Message{{ID: 2}}})
+	p.Close()
+
+	// Both chunks wait in the outbox, the import need not redo them
+	assert.Equal(t, 2, box.Len())
+	assert.Equal(t, int64(2), progress.Last())
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Export is a Telegram Desktop export, read chat by chat and each chat
+// message by message
+type Export interface {
+	// NextChat moves to the next chat, or returns io.EOF after the last one
+	NextChat() (*TelegramBackup, error)
+	// Next returns the next message of the chat, or io.EOF after its last one
+	Next() (Message, error)
+}
+
+// ExportReader reads a Telegram Desktop JSON export one message at a time.
+// Only the message being decoded is held in memory, so exports of any size
+// can be imported. Both layouts are read: a single-chat result.json, and a
+// full-account export listing its chats under chats.list and
+// left_chats.list.
+type ExportReader struct {
+	decoder *json.Decoder
+	chat    TelegramBackup // Header of the current chat, Messages stays empty
+
+	section    bool  // Inside the chats or left_chats object of an account export
+	list       bool  // Inside the list of chats of that section
+	inMessages bool  // Inside the messages array of the current chat
+	done       bool  // The export has been read to the end
+	err        error // First read error, returned from then on
+}
+
+// NewExportReader starts reading an export. Chats are read with NextChat,
+// their messages with Next.
+func NewExportReader(r io.Reader) (*ExportReader, error) {
+	reader := &ExportReader{decoder: json.NewDecoder(r)}
+	if err := reader.expectDelim('{'); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// NextChat reads the header of the next chat with messages, up to the first
+// message, or returns io.EOF after the last one. Messages of the previous
+// chat that have not been read are skipped. A chat's id has to come before
+// its messages, as it does in every export Telegram Desktop writes.
+func (r *ExportReader) NextChat() (*TelegramBackup, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	chat, err := r.nextChat()
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return chat, err
+}
+
+func (r *ExportReader) nextChat() (*TelegramBackup, error) {
+	if r.inMessages {
+		for r.decoder.More() {
+			if err := r.skip(); err != nil {
+				return nil, err
+			}
+		}
+		if err := r.closeChat(); err != nil {
+			return nil, err
+		}
+	}
+
+	for !r.done {
+		switch {
+		case r.list:
+			// One chat object per entry
+			if !r.decoder.More() {
+				r.list = false
+				if err := r.expectDelim(']'); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := r.expectDelim('{'); err != nil {
+				return nil, err
+			}
+			r.chat = TelegramBackup{}
+			found, err := r.listChat()
+			if err != nil {
+				return nil, err
+			}
+			if found {
+				chat := r.chat
+				return &chat, nil
+			}
+
+		case r.section:
+			if !r.decoder.More() {
+				r.section = false
+				if err := r.expectDelim('}'); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			key, err := r.key()
+			if err != nil {
+				return nil, err
+			}
+			if key == "list" {
+				if err := r.expectDelim('['); err != nil {
+					return nil, err
+				}
+				r.list = true
+				continue
+			}
+			if err := r.skip(); err != nil {
+				return nil, err
+			}
+
+		default:
+			// The top-level object: a single chat, or the account
+			if !r.decoder.More() {
+				r.done = true
+				if err := r.expectDelim('}'); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			key, err := r.key()
+			if err != nil {
+				return nil, err
+			}
+			switch key {
+			case "messages":
+				if err := r.openMessages(); err != nil {
+					return nil, err
+				}
+				chat := r.chat
+				return &chat, nil
+			case "chats", "left_chats":
+				if err := r.expectDelim('{'); err != nil {
+					return nil, err
+				}
+				r.section = true
+			default:
+				if err := r.headerValue(key); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return nil, io.EOF
+}
+
+// listChat reads a chat of an account export up to its messages. It
+// reports false for a chat without a messages array, which is skipped.
+func (r *ExportReader) listChat() (bool, error) {
+	for r.decoder.More() {
+		key, err := r.key()
+		if err != nil {
+			return false, err
+		}
+		if key == "messages" {
+			return true, r.openMessages()
+		}
+		if err := r.headerValue(key); err != nil {
+			return false, err
+		}
+	}
+	return false, r.expectDelim('}')
+}
+
+// openMessages enters the messages array of the current chat
+func (r *ExportReader) openMessages() error {
+	if r.chat.ID == 0 {
+		return fmt.Errorf("chat '%s' of export has no id before its messages", r.chat.Name)
+	}
+	if err := r.expectDelim('['); err != nil {
+		return err
+	}
+	r.inMessages = true
+	return nil
+}
+
+// closeChat reads the end of the messages array and, for a chat of an
+// account export, the rest of its object
+func (r *ExportReader) closeChat() error {
+	r.inMessages = false
+	if err := r.expectDelim(']'); err != nil {
+		return err
+	}
+	if !r.list {
+		// The rest of a single-chat export is read by NextChat
+		return nil
+	}
+	for r.decoder.More() {
+		if _, err := r.key(); err != nil {
+			return err
+		}
+		if err := r.skip(); err != nil {
+			return err
+		}
+	}
+	return r.expectDelim('}')
+}
+
+// Next returns the next message of the current chat, or io.EOF after its
+// last one. A truncated or malformed export is reported as an error, not
+// as io.EOF.
+func (r *ExportReader) Next() (Message, error) {
+	if r.err != nil {
+		return Message{}, r.err
+	}
+	if !r.inMessages {
+		return Message{}, io.EOF
+	}
+	if !r.decoder.More() {
+		if err := r.closeChat(); err != nil {
+			r.err = err
+			return Message{}, err
+		}
+		return Message{}, io.EOF
+	}
+
+	var message Message
+	if err := r.decoder.Decode(&message); err != nil {
+		r.err = fmt.Errorf("error reading message at byte %d: %v", r.decoder.InputOffset(), err)
+		return Message{}, r.err
+	}
+	return message, nil
+}
+
+// headerValue decodes a chat's value into the header, or skips it
+func (r *ExportReader) headerValue(key string) error {
+	var err error
+	switch key {
+	case "name":
+		err = r.decoder.Decode(&r.chat.Name)
+	case "type":
+		err = r.decoder.Decode(&r.chat.Type)
+	case "id":
+		err = r.decoder.Decode(&r.chat.ID)
+	default:
+		err = r.skip()
+	}
+	if err != nil {
+		return fmt.Errorf("error reading '%s' of export: %v", key, err)
+	}
+	return nil
+}
+
+// key reads an object key
+func (r *ExportReader) key() (string, error) {
+	token, err := r.token()
+	if err != nil {
+		return "", err
+	}
+	key, ok := token.(string)
+	if !ok {
+		return "", fmt.Errorf("invalid export: expected a key at byte %d, got %v", r.decoder.InputOffset(), token)
+	}
+	return key, nil
+}
+
+// skip reads past the next value token by token, without holding it in
+// memory
+func (r *ExportReader) skip() error {
+	depth := 0
+	for {
+		token, err := r.token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+func (r *ExportReader) expectDelim(delim json.Delim) error {
+	token, err := r.token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("invalid export: expected '%s' at byte %d, got %v", delim, r.decoder.InputOffset(), token)
+	}
+	return nil
+}
+
+// token reads the next token, reporting the end of the input as truncation
+func (r *ExportReader) token() (json.Token, error) {
+	token, err := r.decoder.Token()
+	if err == io.EOF {
+		return nil, fmt.Errorf("export is truncated after %d bytes", r.decoder.InputOffset())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid export at byte %d: %v", r.decoder.InputOffset(), err)
+	}
+	return token, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testExport = `{
+ "name": "Test group",
+ "type": "private_supergroup",
+ "id": 1234567890,
+ "messages": [
+  {"id": 1, "type": "service", "date_unixtime": "1744962600", "action": "create_group"},
+  {"id": 2, "type": "message", "date_unixtime": "1744962615", "from": "user1", "from_id": "user1", "text": "hello"},
+  {"id": 3, "type": "message", "date_unixtime": "1744962620", "from": "user2", "from_id": "user2", "reply_to_message_id": 2,
+   "text": ["see ", {"type": "link", "text": "https://example.com"}, " for details"],
+   "text_entities": [{"type": "plain", "text": "see "}]}
+ ]
+}`
+
+// readAll reads every message of the current chat
+func readAll(t *testing.T, export Export) []Message {
+	var messages []Message
+	for {
+		message, err := export.Next()
+		if err == io.EOF {
+			return messages
+		}
+		require.NoError(t, err)
+		messages = append(messages, message)
+	}
+}
+
+func TestExportReaderMatchesUnmarshal(t *testing.T) {
+	var backup TelegramBackup
+	require.NoError(t, json.Unmarshal([]byte(testExport), &backup))
+
+	export, err := NewExportReader(strings.NewReader(testExport))
+	require.NoError(t, err)
+	chat, err := export.NextChat()
+	require.NoError(t, err)
+	assert.Equal(t, "Test group", chat.Name)
+	assert.Equal(t, int64(-1001234567890), chat.BotChatID())
+
+	assert.Equal(t, backup.Messages, readAll(t, export))
+
+	// The end stays the end
+	_, err = export.Next()
+	assert.Equal(t, io.EOF, err)
+	_, err = export.NextChat()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestExportReaderMixedText(t *testing.T) {
+	export, err := NewExportReader(strings.NewReader(testExport))
+	require.NoError(t, err)
+	_, err = export.NextChat()
+	require.NoError(t, err)
+	messages := readAll(t, export)
+	require.Len(t, messages, 3)
+
+	text, err := messages[2].GetText()
+	assert.NoError(t, err)
+	assert.Equal(t, "see https://example.com for details", text)
+	assert.Equal(t, int64(2), messages[2].ReplyToID)
+}
+
+func TestExportReaderSkipsUnknownKeys(t *testing.T) {
+	raw := `{"about": "", "photo": {"sizes": [[1, 2], {"x": "]"}]}, "id": 42, "type": "personal_chat",
+		"messages": [{"id": 1, "type": "message", "text": "hi"}], "frozen": [{}]}`
+
+	export, err := NewExportReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	chat, err := export.NextChat()
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), chat.ID)
+	assert.Len(t, readAll(t, export), 1)
+	_, err = export.NextChat()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestExportReaderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"truncated in a message", testExport[:strings.Index(testExport, `"hello"`)]},
+		{"truncated after a message", testExport[:strings.LastIndex(testExport, "]")]},
+		{"missing closing brace", strings.TrimSuffix(testExport, "}")},
+		{"malformed message", `{"id": 1, "messages": [{"id": 1, "text": }]}`},
+	}
+
+	for _, tt := range tests {
+		export, err := NewExportReader(strings.NewReader(tt.raw))
+		require.NoError(t, err, tt.name)
+		_, err = export.NextChat()
+		require.NoError(t, err, tt.name)
+
+		for err == nil {
+			_, err = export.Next()
+		}
+		if err == io.EOF {
+			// The damage is after the messages
+			_, err = export.NextChat()
+		}
+		assert.NotEqual(t, io.EOF, err, tt.name)
+	}
+}
+
+func TestExportReaderHeaderErrors(t *testing.T) {
+	for name, raw := range map[string]string{
+		"not an object":           `[]`,
+		"id after the messages":   `{"messages": [], "id": 1}`,
+		"messages not an array":   `{"id": 1, "messages": {}}`,
+		"truncated in the header": `{"name": "Test group", "id"`,
+	} {
+		export, err := NewExportReader(strings.NewReader(raw))
+		if err == nil {
+			_, err = export.NextChat()
+		}
+		assert.Error(t, err, name)
+	}
+}
+
+const testAccountExport = `{
+ "about": "Here is the data you requested.",
+ "personal_information": {"user_id": 1, "first_name": "Test"},
+ "contacts": {"about": "", "list": [{"user_id": 2, "first_name": "Other"}]},
+ "chats": {
+  "about": "This page lists all chats from this export.",
+  "list": [
+   {"name": "Saved Messages", "type": "saved_messages", "id": 1, "messages": [
+    {"id": 1, "type": "message", "text": "note to self"}
+   ]},
+   {"name": "Empty", "type": "personal_chat", "id": 2},
+   {"name": "Test group", "type": "private_supergroup", "id": 1234567890, "messages": [
+    {"id": 10, "type": "message", "text": "hello"},
+    {"id": 11, "type": "message", "text": ["mixed ", {"type": "bold", "text": "text"}]}
+   ], "extra": {"nested": [1, 2]}}
+  ]
+ },
+ "left_chats": {"about": "", "list": [
+  {"name": "Old group", "type": "private_group", "id": 77, "messages": [{"id": 5, "type": "message", "text": "bye"}]}
+ ]},
+ "profile_pictures": []
+}`
+
+func TestExportReaderAccountExport(t *testing.T) {
+	export, err := NewExportReader(strings.NewReader(testAccountExport))
+	require.NoError(t, err)
+
+	var names []string
+	var texts []string
+	for {
+		chat, err := export.NextChat()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, chat.Name)
+		for _, message := range readAll(t, export) {
+			text, err := message.GetText()
+			require.NoError(t, err)
+			texts = append(texts, text)
+		}
+	}
+
+	// The chat without messages is left out
+	assert.Equal(t, []string{"Saved Messages", "Test group", "Old group"}, names)
+	assert.Equal(t, []string{"note to self", "hello", "mixed text", "bye"}, texts)
+}
+
+func TestExportReaderSkipsUnreadMessages(t *testing.T) {
+	export, err := NewExportReader(strings.NewReader(testAccountExport))
+	require.NoError(t, err)
+
+	// Chats can be passed over without reading their messages
+	var ids []int64
+	for {
+		chat, err := export.NextChat()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		ids = append(ids, chat.ID)
+		if chat.ID == 1234567890 {
+			message, err := export.Next()
+			require.NoError(t, err)
+			assert.Equal(t, int64(10), message.ID)
+		}
+	}
+	assert.Equal(t, []int64{1, 1234567890, 77}, ids)
+}
+
+func TestChatFilter(t *testing.T) {
+	group := &TelegramBackup{Name: "Test group", Type: "private_supergroup", ID: 1234567890}
+	personal := &TelegramBackup{Name: "Alice", Type: "personal_chat", ID: 42}
+
+	tests := []struct {
+		name     string
+		filter   chatFilter
+		group    bool
+		personal bool
+	}{
+		{"empty", chatFilter{}, true, true},
+		{"export id", chatFilter{chats: []string{"1234567890"}}, true, false},
+		{"bot chat id", chatFilter{chats: []string{"-1001234567890"}}, true, false},
+		{"name", chatFilter{chats: []string{"alice"}}, false, true},
+		{"type", chatFilter{types: []string{"private_supergroup", "public_supergroup"}}, true, false},
+		{"type and name", chatFilter{chats: []string{"Alice"}, types: []string{"private_supergroup"}}, false, false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.group, tt.filter.Match(group), tt.name)
+		assert.Equal(t, tt.personal, tt.filter.Match(personal), tt.name)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// htmlFilePattern matches the pages of an HTML export: messages.html,
+// messages2.html, ...
+var htmlFilePattern = regexp.MustCompile(`^messages(\d*)\.html$`)
+
+// htmlDateLayouts are the formats of a message's date tooltip. Newer
+// versions of Telegram Desktop add the UTC offset, older ones write local
+// time.
+var htmlDateLayouts = []string{"02.01.2006 15:04:05 UTC-07:00", "02.01.2006 15:04:05"}
+
+// HTMLExportReader reads a Telegram Desktop HTML export of a single chat:
+// a directory of messages.html, messages2.html, ... pages of about a
+// thousand messages each. One page is held in memory at a time.
+//
+// The pages name the chat but carry neither its ID nor its type, so the
+// chat ID the bot sees has to be given. Senders are known by name only,
+// FromID stays empty.
+type HTMLExportReader struct {
+	files    []string       // Pages in message order
+	location *time.Location // For dates without a UTC offset
+	chat     TelegramBackup
+
+	started  bool      // NextChat has returned the chat
+	messages []Message // Messages of the current page not yet returned
+	from     string    // Sender of the previous message, for joined ones
+	err      error     // First read error, returned from then on
+}
+
+// IsHTMLExport reports whether path is an HTML export: a directory with a
+// messages.html page, or one of the pages
+func IsHTMLExport(path string) bool {
+	info, err := os.Stat(path)
+	if err == nil && info.IsDir() {
+		_, err = os.Stat(filepath.Join(path, "messages.html"))
+		return err == nil
+	}
+	return strings.EqualFold(filepath.Ext(path), ".html")
+}
+
+// NewHTMLExportReader finds the pages of the export in path, the export's
+// directory or one of its pages. chatID is the ID the bot sees for the
+// chat; dates without a UTC offset are read in location.
+func NewHTMLExportReader(path string, chatID int64, location *time.Location) (*HTMLExportReader, error) {
+	if chatID == 0 {
+		return nil, errors.New("HTML exports do not contain the chat ID, it has to be given")
+	}
+
+	dir := path
+	if info, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		dir = filepath.Dir(path)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	pages := make(map[string]int)
+	var files []string
+	for _, entry := range entries {
+		match := htmlFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil || entry.IsDir() {
+			continue
+		}
+		// messages.html is the first page
+		page := 1
+		if match[1] != "" {
+			page, _ = strconv.Atoi(match[1])
+		}
+		file := filepath.Join(dir, entry.Name())
+		pages[file] = page
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no messages.html in %s", dir)
+	}
+	sort.Slice(files, func(i, j int) bool { return pages[files[i]] < pages[files[j]] })
+
+	return &HTMLExportReader{
+		files:    files,
+		location: location,
+		chat:     TelegramBackup{ID: chatID},
+	}, nil
+}
+
+// NextChat returns the chat with its name from the first page, or io.EOF
+// once it has been returned
+func (r *HTMLExportReader) NextChat() (*TelegramBackup, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.started {
+		return nil, io.EOF
+	}
+	r.started = true
+	if err := r.readPage(); err != nil {
+		r.err = err
+		return nil, err
+	}
+	chat := r.chat
+	return &chat, nil
+}
+
+// Next returns the next message of the chat, or io.EOF after the last one
+func (r *HTMLExportReader) Next() (Message, error) {
+	if r.err != nil {
+		return Message{}, r.err
+	}
+	if !r.started {
+		return Message{}, io.EOF
+	}
+	for len(r.messages) == 0 {
+		if len(r.files) == 0 {
+			return Message{}, io.EOF
+		}
+		if err := r.readPage(); err != nil {
+			r.err = err
+			return Message{}, err
+		}
+	}
+
+	message := r.messages[0]
+	r.messages = r.messages[1:]
+	return message, nil
+}
+
+// readPage parses the next page into its messages
+func (r *HTMLExportReader) readPage() error {
+	file := r.files[0]
+	r.files = r.files[1:]
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	doc, err := html.Parse(f)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %v", file, err)
+	}
+
+	var walk func(n *html.Node) error
+	walk = func(n *html.Node) error {
+		if n.Type == html.ElementNode && n.Data == "div" {
+			switch {
+			case hasClass(n, "page_header"):
+				if title := findClass(n, "bold"); title != nil && r.chat.Name == "" {
+					r.chat.Name = strings.TrimSpace(nodeText(title))
+				}
+				return nil
+			case hasClass(n, "message"):
+				message, ok, err := r.message(n)
+				if err != nil {
+					return fmt.Errorf("error reading %s: %v", file, err)
+				}
+				if ok {
+					r.messages = append(r.messages, message)
+				}
+				return nil
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if err := walk(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(doc)
+}
+
+// message reads a message div into the fields the JSON export has. The
+// date separators between days are reported as not ok.
+func (r *HTMLExportReader) message(n *html.Node) (Message, bool, error) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(attr(n, "id"), "message"), 10, 64)
+	if err != nil {
+		return Message{}, false, fmt.Errorf("message with invalid id '%s'", attr(n, "id"))
+	}
+	if id <= 0 {
+		return Message{}, false, nil
+	}
+
+	message := Message{ID: id, Type: "message"}
+	if hasClass(n, "service") {
+		message.Type = "service"
+		return message, true, nil
+	}
+
+	body := childClass(n, "body")
+	if body == nil {
+		return message, true, nil
+	}
+
+	if date := findClass(body, "date"); date != nil {
+		t, err := r.parseDate(attr(date, "title"))
+		if err != nil {
+			return Message{}, false, fmt.Errorf("message %d: %v", id, err)
+		}
+		message.Date = t.Format("2006-01-02T15:04:05")
+		message.DateUnixtime = strconv.FormatInt(t.Unix(), 10)
+	}
+
+	// Joined messages continue the previous sender's, a forwarded message
+	// names its original sender further down
+	if from := childClass(body, "from_name"); from != nil {
+		r.from = strings.TrimSpace(nodeText(from))
+	}
+	message.From = r.from
+
+	if reply := findClass(body, "reply_to"); reply != nil {
+		if link := findElement(reply, "a"); link != nil {
+			href := attr(link, "href")
+			if i := strings.Index(href, "go_to_message"); i >= 0 {
+				message.ReplyToID, _ = strconv.ParseInt(href[i+len("go_to_message"):], 10, 64)
+			}
+		}
+	}
+
+	if text := findClass(body, "text"); text != nil {
+		if s := strings.TrimSpace(nodeText(text)); s != "" {
+			message.Text, _ = json.Marshal(s)
+		}
+	}
+	return message, true, nil
+}
+
+func (r *HTMLExportReader) parseDate(title string) (time.Time, error) {
+	for _, layout := range htmlDateLayouts {
+		if t, err := time.ParseInLocation(layout, title, r.location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date '%s'", title)
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, c := range strings.Fields(attr(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// childClass returns the first direct child element with the class
+func childClass(n *html.Node, class string) *html.Node {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && hasClass(child, class) {
+			return child
+		}
+	}
+	return nil
+}
+
+// findClass returns the first descendant element with the class
+func findClass(n *html.Node, class string) *html.Node {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && hasClass(child, class) {
+			return child
+		}
+		if found := findClass(child, class); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// findElement returns the first descendant element with the tag
+func findElement(n *html.Node, tag string) *html.Node {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.ElementNode && child.Data == tag {
+			return child
+		}
+		if found := findElement(child, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// nodeText returns the text of an element as the JSON export writes it:
+// formatting dropped, links by their text, line breaks as newlines
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			b.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.Data == "br":
+			b.WriteString("\n")
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return b.String()
+}
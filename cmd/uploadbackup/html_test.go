@@ -0,0 +1,235 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// htmlPage wraps messages in a page as Telegram Desktop writes it
+func htmlPage(messages string) string {
+	return `<!DOCTYPE html>
+<html>
+ <head><meta charset="utf-8"/><title>Exported Data</title></head>
+ <body>
+  <div class="page_wrap">
+   <div class="page_header">
+    <div class="content">
+     <div class="text bold">
+Test group
+     </div>
+    </div>
+   </div>
+   <div class="page_body chat_page">
+    <div class="history">
+` + messages + `
+    </div>
+   </div>
+  </div>
+ </body>
+</html>`
+}
+
+const htmlFirstPage = `
+     <div class="message service" id="message-1">
+      <div class="body details">
+18 April 2025
+      </div>
+     </div>
+     <div class="message service" id="message1">
+      <div class="body details">
+Alice created group «Test group»
+      </div>
+     </div>
+     <div class="message default clearfix" id="message2">
+      <div class="pull_left userpic_wrap">
+       <div class="userpic userpic2" style="width: 42px; height: 42px">
+        <div class="initials" style="line-height: 42px">A</div>
+       </div>
+      </div>
+      <div class="body">
+       <div class="pull_right date details" title="18.04.2025 10:30:15 UTC+02:00">
+10:30
+       </div>
+       <div class="from_name">
+Alice
+       </div>
+       <div class="text">
+Hello &amp; welcome<br>see <a href="https://example.com">https://example.com</a> for <strong>details</strong>
+       </div>
+      </div>
+     </div>
+     <div class="message default clearfix joined" id="message3">
+      <div class="body">
+       <div class="pull_right date details" title="18.04.2025 10:31:00 UTC+02:00">
+10:31
+       </div>
+       <div class="media_wrap clearfix">
+        <div class="media clearfix pull_left media_photo">
+         <div class="fill pull_left"></div>
+         <div class="body">
+          <div class="title bold">Photo</div>
+          <div class="status details">Not included, change data exporting settings to download.</div>
+         </div>
+        </div>
+       </div>
+      </div>
+     </div>`
+
+const htmlSecondPage = `
+     <div class="message default clearfix joined" id="message4">
+      <div class="body">
+       <div class="pull_right date details" title="18.04.2025 10:32:00 UTC+02:00">
+10:32
+       </div>
+       <div class="text">
+still Alice
+       </div>
+      </div>
+     </div>
+     <div class="message default clearfix" id="message5">
+      <div class="body">
+       <div class="pull_right date details" title="18.04.2025 10:40:00">
+10:40
+       </div>
+       <div class="from_name">
+Bob
+       </div>
+       <div class="reply_to details">
+In reply to <a href="messages.html#go_to_message2" onclick="return GoToMessage(2)">this message</a>
+       </div>
+       <div class="text">
+agreed
+       </div>
+      </div>
+     </div>
+     <div class="message default clearfix joined" id="message6">
+      <div class="body">
+       <div class="pull_right date details" title="18.04.2025 10:41:00">
+10:41
+       </div>
+       <div class="forwarded body">
+        <div class="from_name">
+Carol<span class="date details" title="01.01.2025 09:00:00"> 01.01.2025 09:00:00</span>
+        </div>
+        <div class="text">
+forwarded text
+        </div>
+       </div>
+      </div>
+     </div>`
+
+// writeHTMLExport writes an export of two pages, the second named so that
+// it sorts before the first by name
+func writeHTMLExport(t *testing.T) string {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "messages.html"), []byte(htmlPage(htmlFirstPage)), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "messages2.html"), []byte(htmlPage(htmlSecondPage)), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "messages10.html"), []byte(htmlPage("")), 0o600))
+	return dir
+}
+
+func TestHTMLExportReader(t *testing.T) {
+	dir := writeHTMLExport(t)
+	assert.True(t, IsHTMLExport(dir))
+
+	location := time.FixedZone("UTC+3", 3*60*60)
+	export, err := NewHTMLExportReader(dir, -1001234567890, location)
+	require.NoError(t, err)
+
+	chat, err := export.NextChat()
+	require.NoError(t, err)
+	assert.Equal(t, "Test group", chat.Name)
+	assert.Equal(t, int64(-1001234567890), chat.BotChatID())
+
+	messages := readAll(t, export)
+	_, err = export.NextChat()
+	assert.Equal(t, io.EOF, err)
+
+	// The date separator is not a message
+	require.Len(t, messages, 6)
+	ids := make([]int64, len(messages))
+	for i, message := range messages {
+		ids[i] = message.ID
+	}
+	assert.Equal(t, []int64{1, 2, 3, 4, 5, 6}, ids)
+	assert.Equal(t, "service", messages[0].Type)
+
+	text, err := messages[1].GetText()
+	require.NoError(t, err)
+	assert.Equal(t, "Hello & welcome\nsee https://example.com for details", text)
+	assert.Equal(t, "Alice", messages[1].From)
+	assert.Equal(t, "2025-04-18T10:30:15", messages[1].Date)
+	assert.Equal(t, "1744965015", messages[1].DateUnixtime)
+
+	// A photo without caption has no text
+	text, err = messages[2].GetText()
+	require.NoError(t, err)
+	assert.Equal(t, "", text)
+
+	// Joined messages keep their sender, also across pages
+	assert.Equal(t, "Alice", messages[2].From)
+	assert.Equal(t, "Alice", messages[3].From)
+
+	// Dates without offset are in the given location
+	assert.Equal(t, "Bob", messages[4].From)
+	assert.Equal(t, int64(2), messages[4].ReplyToID)
+	assert.Equal(t, time.Date(2025, 4, 18, 10, 40, 0, 0, location).Unix(), mustParseTimestamp(t, messages[4].DateUnixtime))
+
+	// A forwarded message is stored as sent by whoever forwarded it
+	text, err = messages[5].GetText()
+	require.NoError(t, err)
+	assert.Equal(t, "forwarded text", text)
+	assert.Equal(t, "Bob", messages[5].From)
+}
+
+func TestHTMLExportReaderFromPage(t *testing.T) {
+	dir := writeHTMLExport(t)
+	page := filepath.Join(dir, "messages.html")
+	assert.True(t, IsHTMLExport(page))
+
+	// Any page stands for the whole export
+	export, err := NewHTMLExportReader(page, 42, time.UTC)
+	require.NoError(t, err)
+	_, err = export.NextChat()
+	require.NoError(t, err)
+	assert.Len(t, readAll(t, export), 6)
+}
+
+func TestHTMLExportReaderErrors(t *testing.T) {
+	dir := writeHTMLExport(t)
+
+	_, err := NewHTMLExportReader(dir, 0, time.UTC)
+	assert.Error(t, err, "chat ID missing")
+
+	_, err = NewHTMLExportReader(t.TempDir(), 42, time.UTC)
+	assert.Error(t, err, "no pages")
+
+	bad := strings.Replace(htmlSecondPage, `title="18.04.2025 10:40:00"`, `title="yesterday"`, 1)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "messages2.html"), []byte(htmlPage(bad)), 0o600))
+	export, err := NewHTMLExportReader(dir, 42, time.UTC)
+	require.NoError(t, err)
+	_, err = export.NextChat()
+	require.NoError(t, err)
+	for err == nil {
+		_, err = export.Next()
+	}
+	assert.NotEqual(t, io.EOF, err)
+}
+
+func TestIsHTMLExport(t *testing.T) {
+	assert.False(t, IsHTMLExport("result.json"))
+	assert.False(t, IsHTMLExport(t.TempDir()))
+}
+
+func mustParseTimestamp(t *testing.T, s string) int64 {
+	timestamp, err := parseTimestamp(s)
+	require.NoError(t, err)
+	return timestamp
+}
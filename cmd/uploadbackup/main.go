@@ -1,115 +1,314 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
+	"path/filepath"
 
 	"github.com/cheggaaa/pb/v3"
 	"github.com/korjavin/ragtgbot/internal/buffer"
+	"github.com/korjavin/ragtgbot/internal/config"
+	"github.com/korjavin/ragtgbot/internal/embedding"
+	"github.com/korjavin/ragtgbot/internal/outbox"
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+	"github.com/korjavin/ragtgbot/internal/resilient"
+	"github.com/korjavin/ragtgbot/internal/schema"
 )
 
-var qdrantBaseURL string // Base URL for Qdrant service
+var (
+	collectionName       = config.Default().Qdrant.Collection // Alias the points are written to, set from the configuration
+	keywordSearchEnabled bool                                 // Whether the collection has the sparse "text" vector
+)
 
 func main() {
-	// Get filename from arguments
-	if len(os.Args) != 2 {
-		fmt.Println("Usage: go run cmd/uploadbackup/main.go <filename>")
+	workers := flag.Int("workers", defaultWorkers, "number of concurrent embedding requests")
+	embedBatch := flag.Int("embed-batch", defaultEmbedBatch, "chunks per embedding request")
+	upsertBatch := flag.Int("upsert-batch", defaultUpsertBatch, "points per Qdrant upsert request")
+	configPath := flag.String("config", os.Getenv(config.FileEnv), "YAML or TOML config file, environment variables override it")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
+	resume := flag.Bool("resume", false, "skip messages an earlier import of the file already persisted")
+	checkpointPath := flag.String("checkpoint", "", "checkpoint file (default <filename>.checkpoint)")
+	htmlChatID := flag.Int64("chat-id", 0, "chat ID the bot sees for the chat of an HTML export, which does not contain it")
+	var filter chatFilter
+	flag.Var((*listFlag)(&filter.chats), "chat", "import only this chat, by ID or name; may be repeated")
+	flag.Var((*listFlag)(&filter.types), "chat-type", "import only chats of this type, e.g. private_supergroup; may be repeated")
+	flag.Parse()
+
+	// Same settings as the bot, checked before anything is read or written
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Printf("Invalid configuration:\n%v\n", err)
+		return
+	}
+	if *printConfig {
+		fmt.Println(cfg.Redacted())
 		return
 	}
-	filename := os.Args[1]
 
-	// Determine Qdrant URL from environment variable or use default
-	qdrantAddr := os.Getenv("QDRANT_SERVICE_ADDRESS")
-	if qdrantAddr != "" {
-		qdrantBaseURL = qdrantAddr
-		// log.Printf("Using Qdrant address from env: %s", qdrantBaseURL) // Removed logging
+	// Get filename from arguments
+	if flag.NArg() != 1 || *workers < 1 || *embedBatch < 1 || *upsertBatch < 1 {
+		fmt.Println("Usage: go run ./cmd/uploadbackup [-config FILE] [-workers N] [-embed-batch N] [-upsert-batch N] [-resume] [-checkpoint FILE] [-chat ID|NAME] [-chat-type TYPE] [-chat-id ID] <filename|html-export-dir>")
+		return
+	}
+	filename := flag.Arg(0)
+
+	collectionName = cfg.Qdrant.Collection
+	// Failed requests are retried, so a brief Qdrant or embedding service
+	// restart does not fail the import
+	store := qdrant.NewClient(cfg.Qdrant.Address, resilient.NewLoggingClient("qdrant", resilient.DefaultPolicy(cfg.Qdrant.Timeout)))
+
+	// 1. Open the export. It is streamed message by message, exports of
+	// big groups run to gigabytes and must not be loaded at once.
+	var export Export
+	if IsHTMLExport(filename) {
+		if *htmlChatID == 0 {
+			fmt.Println("HTML exports do not contain the chat ID: give the ID the bot sees with -chat-id")
+			return
+		}
+		location, err := cfg.Telegram.Location()
+		if err != nil {
+			fmt.Printf("Invalid timezone: %v\n", err)
+			return
+		}
+		export, err = NewHTMLExportReader(filename, *htmlChatID, location)
+		if err != nil {
+			fmt.Printf("Error reading Telegram HTML export: %v\n", err)
+			return
+		}
 	} else {
-		qdrantBaseURL = "http://localhost:6333" // Default URL
-		// log.Printf("Using default Qdrant address: %s", qdrantBaseURL) // Removed logging
+		jsonFile, err := os.Open(filename)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		defer jsonFile.Close()
+
+		export, err = NewExportReader(jsonFile)
+		if err != nil {
+			fmt.Printf("Error reading Telegram export: %v\n", err)
+			return
+		}
+	}
+
+	// The checkpoint records how far the import got, so an interrupted one
+	// can be resumed instead of started over
+	if *checkpointPath == "" {
+		*checkpointPath = filepath.Clean(filename) + ".checkpoint"
+	}
+	checkpoint := NewCheckpoint(*checkpointPath, collectionName)
+	if *resume {
+		checkpoint, err = OpenCheckpoint(*checkpointPath, collectionName)
+		if err != nil {
+			fmt.Printf("Error opening checkpoint: %v\n", err)
+			return
+		}
 	}
 
-	// 1. Read the JSON file
-	jsonFile, err := os.Open(filename)
+	// The collection is created for, and checked against, the embedding model's dimension
+	embeddingConfig := cfg.Embedding.EmbedderConfig(resilient.NewLoggingClient("embedding", resilient.DefaultPolicy(cfg.Embedding.Timeout)))
+	embedder, err := embedding.New(context.Background(), embeddingConfig)
+	if err != nil {
+		fmt.Printf("Error setting up embedding backend: %v\n", err)
+		return
+	}
+	fmt.Printf("Using embedding model '%s' with dimension %d\n", embedder.ModelID(), embedder.Dimension())
+
+	// Re-imports only embed chunks that changed; with embedding.cache_path set
+	// this holds across runs and the file can be shared with a stopped bot
+	cacheConfig := cfg.Embedding.CacheConfig()
+	cache, err := embedding.NewCache(embedder, cacheConfig)
+	if errors.Is(err, embedding.ErrCacheLocked) {
+		fmt.Printf("%v, caching in memory only\n", err)
+		cacheConfig.Path = ""
+		cache, err = embedding.NewCache(embedder, cacheConfig)
+	}
+	if err != nil {
+		fmt.Printf("Error opening embedding cache: %v\n", err)
+		return
+	}
+	defer cache.Close()
+
+	// Create Qdrant collection if it doesn't exist, never touch an incompatible one
+	status, err := schema.Ensure(context.Background(), store, collectionName, embedder.Dimension())
+	var dimErr *schema.DimensionError
+	if errors.As(err, &dimErr) {
+		fmt.Printf("Embedding model '%s' produces %d-dimensional vectors, collection '%s' needs %d: "+
+			"switch back to the model the collection was built with or re-embed it\n", embedder.ModelID(), dimErr.Want, collectionName, dimErr.Have)
+		return
+	}
 	if err != nil {
-		fmt.Println(err)
+		fmt.Printf("Error checking Qdrant collection: %v\n", err)
 		return
 	}
-	defer jsonFile.Close()
+	// Collections created before hybrid search have no sparse vector
+	keywordSearchEnabled = status.KeywordSearch()
+
+	// Failed chunks are kept in the outbox instead of being dropped. Imports
+	// have a directory of their own, the bot keeps its outbox open.
+	var box *outbox.Outbox
+	if cfg.Outbox.Dir != "" {
+		outboxDir := outbox.ImportDir(cfg.Outbox.Dir)
+		box, err = outbox.Open(outboxDir, cfg.Outbox.MaxAttempts)
+		if errors.Is(err, outbox.ErrLocked) {
+			// Another import is running. Failed chunks then hold the
+			// checkpoint back, -resume retries them.
+			fmt.Printf("%v, failed chunks are not kept\n", err)
+		} else if err != nil {
+			fmt.Printf("Error opening outbox: %v\n", err)
+			return
+		} else {
+			defer box.Close()
+		}
+	}
 
-	byteValue, _ := io.ReadAll(jsonFile)
+	// Initialize progress bar. The number of messages is unknown until the
+	// end of the export; buffered messages count once their chunk is stored,
+	// everything else as soon as it has been read.
+	bar := pb.StartNew(0)
+
+	im := &importer{
+		store:       store,
+		embedder:    cache,
+		outbox:      box,
+		checkpoint:  checkpoint,
+		chunking:    cfg.Chunking,
+		workers:     *workers,
+		embedBatch:  *embedBatch,
+		upsertBatch: *upsertBatch,
+		bar:         bar,
+	}
 
-	// 2. Parse the JSON
-	var backup TelegramBackup
-	err = json.Unmarshal(byteValue, &backup)
-	if err != nil {
-		fmt.Printf("Error unmarshaling JSON: %v\n", err)
-
-		// Try to unmarshal into a map to see the structure
-		var rawData map[string]interface{}
-		if jsonErr := json.Unmarshal(byteValue, &rawData); jsonErr == nil {
-			if messages, ok := rawData["messages"].([]interface{}); ok {
-				// Find problematic messages
-				for _, msg := range messages { // Use blank identifier for unused index
-					if msgMap, ok := msg.(map[string]interface{}); ok {
-						if text, exists := msgMap["text"]; exists {
-							switch text.(type) {
-							case string:
-								// This is fine
-							case []interface{}:
-								// fmt.Printf("Found array text at message index %d, ID: %v\n",
-								// 	i, msgMap["id"]) // Removed logging
-							default:
-								// fmt.Printf("Found unusual text type at message index %d, type: %T\n",
-								// 	i, text) // Removed logging
-							}
-						}
-					}
-				}
-			}
+	// 2. Import the chats: a single-chat export has one, a full-account
+	// export all of the account's
+	var readErr error
+	chats, imported := 0, 0
+	for {
+		chat, err := export.NextChat()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+		chats++
+		if !filter.Match(chat) {
+			continue
 		}
+		imported++
+		if err := im.importChat(export, chat); err != nil {
+			// Chunks read so far are still stored, a re-run overwrites them
+			readErr = err
+			break
+		}
+	}
+	bar.SetTotal(bar.Current())
+	bar.Finish()
 
-		return
+	if readErr != nil {
+		fmt.Printf("Error reading Telegram export, imported the messages before the error: %v\n", readErr)
+	}
+	fmt.Printf("Imported %d of %d chats in the export.\n", imported, chats)
+	if imported > 0 {
+		fmt.Printf("Checkpoint in %s, -resume continues after it\n", *checkpointPath)
 	}
 
-	// Create Qdrant collection if it doesn't exist
-	err = createQdrantCollection("chat_history")
-	if err != nil {
-		// fmt.Println(err) // Removed logging, continue even if collection creation fails or exists
-		//return // Don't return, just log the error and continue
+	fmt.Printf("Finished processing Telegram backup. Processed %d buffers, %d failed.\n",
+		im.stored, im.failed)
+
+	// Give failed chunks, and those left by earlier runs, one more try
+	if box != nil && box.Len() > 0 {
+		result, err := box.FlushAll(context.Background(), func(ctx context.Context, entry outbox.Entry) error {
+			embeddings, err := cache.Embed(ctx, []string{entry.Text})
+			if err != nil {
+				return err
+			}
+			return store.Upsert(ctx, collectionName, []qdrant.Point{entry.Point(embeddings[0], keywordSearchEnabled)}, true)
+		})
+		if err != nil {
+			fmt.Printf("Error updating the outbox: %v\n", err)
+		}
+		fmt.Printf("Retried the outbox: %d stored, %d still pending, %d moved to the dead letters.\n",
+			result.Stored, box.Len(), result.Dead)
 	}
+	fmt.Printf("Embedding cache: %s\n", cache.Stats())
+}
+
+// importer imports the chats of an export into the collection
+type importer struct {
+	store       *qdrant.Client
+	embedder    embedding.Embedder
+	outbox      *outbox.Outbox
+	checkpoint  *Checkpoint
+	chunking    config.Chunking
+	workers     int
+	embedBatch  int
+	upsertBatch int
+	bar         *pb.ProgressBar
+
+	stored int64 // Chunks written to Qdrant, over all chats
+	failed int64 // Chunks that failed, over all chats
+}
 
-	// Initialize progress bar
-	bar := pb.StartNew(len(backup.Messages))
-	defer bar.Finish()
+// importChat chunks and stores the messages of the export's current chat.
+// It returns the error that stopped reading them, if any.
+func (im *importer) importChat(export Export, chat *TelegramBackup) error {
+	fmt.Printf("Importing chat '%s' (%s %d)\n", chat.Name, chat.Type, chat.ID)
+
+	// Points are tagged with the chat ID the live bot sees for this chat
+	chatID := chat.BotChatID()
+
+	resumeAfter := im.checkpoint.Last(chatID)
+	if resumeAfter > 0 {
+		fmt.Printf("Resuming after message %d\n", resumeAfter)
+	}
+	progress := im.checkpoint.Track(chatID, resumeAfter)
+
+	// Chunks are embedded and stored in the background
+	uploads := newPipeline(im.store, im.embedder, im.outbox, progress, chatID, im.workers, im.embedBatch, im.upsertBatch, im.bar)
 
 	// Initialize message buffer
 	msgBuffer := buffer.NewMessageBuffer()
-	lastMessageID := int64(0)
 	var lastTimestamp int64 = 0
-	processedBufferCount := 0 // Counter for processed buffers
 
-	// 3. Iterate through messages and extract data
-	for _, message := range backup.Messages {
+	// Iterate through messages and extract data
+	var readErr error
+	for {
+		message, err := export.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+
+		// Persisted by an earlier run
+		if message.ID <= resumeAfter {
+			im.bar.Increment()
+			continue
+		}
+
 		if message.Type == "message" {
 			// Extract text using our new method
 			text, err := message.GetText()
 			if err != nil {
 				// fmt.Printf("Error extracting text from message ID %d: %v\n", message.ID, err) // Removed logging
+				im.bar.Increment()
 				continue
 			}
 
 			// Skip messages without text
 			if text == "" {
-				bar.Increment()
+				im.bar.Increment()
 				continue
 			}
 
 			username := message.From
-			lastMessageID = message.ID
 
 			// Parse message timestamp
 			currentTimestamp, err := parseTimestamp(message.DateUnixtime)
@@ -129,187 +328,35 @@ func main() {
 				// Process buffer if:
 				// 1. Buffer exceeds hard limit, or
 				// 2. Buffer exceeds soft limit AND messages are not close in time
-				if msgBuffer.Size >= hardLimitChunkSize ||
-					(msgBuffer.Size >= softLimitChunkSize && !timeProximity) {
-					if err := processBuffer(msgBuffer, lastMessageID); err == nil {
-						processedBufferCount++ // Increment counter on successful processing
-					} else {
-						// fmt.Printf("Error processing buffer at message ID %d: %v\n", lastMessageID, err) // Removed logging
-					}
+				if msgBuffer.Size >= im.chunking.HardLimit ||
+					(msgBuffer.Size >= im.chunking.SoftLimit && !timeProximity) {
+					uploads.Submit(msgBuffer.Snapshot())
 					msgBuffer.Clear()
 				}
 			}
 
 			// Add message to buffer
-			msgBuffer.Add(username, text)
+			msgBuffer.AddMessage(buffer.Message{
+				ID:        message.ID,
+				Username:  username,
+				FromID:    message.FromID,
+				Text:      text,
+				Date:      currentTimestamp,
+				ReplyToID: message.ReplyToID,
+			})
 			lastTimestamp = currentTimestamp
+			continue
 		}
-		bar.Increment()
+		im.bar.Increment()
 	}
 
 	// Process remaining messages in buffer
 	if !msgBuffer.IsEmpty() {
-		if err := processBuffer(msgBuffer, lastMessageID); err == nil {
-			processedBufferCount++ // Increment counter for final buffer
-		} else {
-			// fmt.Printf("Error processing final buffer: %v\n", err) // Removed logging
-		}
-	}
-
-	fmt.Printf("Finished processing Telegram backup. Processed %d buffers.\n", processedBufferCount)
-}
-
-func processBuffer(buffer *buffer.MessageBuffer, messageID int64) error {
-	// Get buffer contents
-	text, username, _ := buffer.GetContents()
-
-	// Get embedding for combined text
-	embedding, err := getEmbedding(text)
-	if err != nil {
-		return fmt.Errorf("error getting embedding: %v", err)
-	}
-
-	// Save to Qdrant
-	err = saveToQdrant(messageID, text, username, embedding)
-	if err != nil {
-		return fmt.Errorf("error saving to Qdrant: %v", err)
-	}
-
-	return nil
-}
-
-func getEmbedding(text string) ([]float64, error) {
-	// Replace with your embedding service URL
-	embeddingServiceURL := "http://localhost:8000/embeddings"
-
-	requestBody, err := json.Marshal(map[string][]string{
-		"texts": {text},
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := http.Post(embeddingServiceURL, "application/json", bytes.NewBuffer(requestBody))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	var embeddingString string
-	err = json.Unmarshal(body, &embeddingString)
-	if err != nil {
-		// log.Println("Error unmarshaling embedding string:", err) // Removed logging
-		return nil, err
-	}
-
-	var embeddingList [][]float64
-	err = json.Unmarshal([]byte(embeddingString), &embeddingList)
-	if err != nil {
-		// log.Println("Error unmarshaling embedding list:", err) // Removed logging
-		return nil, err
-	}
-
-	if len(embeddingList) > 0 {
-		return embeddingList[0], nil
-	}
-
-	return nil, fmt.Errorf("no embedding found")
-}
-
-func saveToQdrant(messageID int64, text string, username string, embedding []float64) error {
-	// Qdrant saving logic using HTTP API
-	qdrantURL := fmt.Sprintf("%s/collections/chat_history/points", qdrantBaseURL)
-
-	point := map[string]interface{}{
-		"id": messageID,
-		"vector": map[string]interface{}{
-			"data": embedding,
-		},
-		"payload": map[string]string{
-			"text":     text,
-			"username": username,
-		},
-	}
-
-	requestBody, err := json.Marshal(map[string][]map[string]interface{}{
-		"points": {point},
-	})
-	if err != nil {
-		return err
+		uploads.Submit(msgBuffer.Snapshot())
 	}
+	uploads.Close()
 
-	req, err := http.NewRequest(http.MethodPut, qdrantURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	_, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	//log.Println(string(body)) // Print the response from Qdrant
-
-	return nil
-}
-
-func createQdrantCollection(collectionName string) error {
-	qdrantURL := fmt.Sprintf("%s/collections/%s", qdrantBaseURL, collectionName)
-
-	// Check if collection exists
-	resp, err := http.Get(qdrantURL)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusOK {
-		// log.Printf("Collection %s already exists\n", collectionName) // Removed logging
-		return nil
-	}
-
-	requestBody, err := json.Marshal(map[string]interface{}{
-		"vectors_config": map[string]interface{}{
-			"size":     512, // Embedding size from istiluse-base-multilingual-cased-v1
-			"distance": "Cosine",
-		},
-	})
-	if err != nil {
-		return err
-	}
-
-	req, err := http.NewRequest(http.MethodPut, qdrantURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err = client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	_, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	//log.Println(string(body)) // Print the response from Qdrant
-
-	return nil
+	im.stored += uploads.stored.Load()
+	im.failed += uploads.failed.Load()
+	return readErr
 }
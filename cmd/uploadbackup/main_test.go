@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/korjavin/ragtgbot/internal/buffer"
+	"github.com/korjavin/ragtgbot/internal/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -25,6 +26,7 @@ func createTestMessage(id int64, text string, timestamp int64) Message {
 
 // setupTestBuffer creates a function that simulates processing messages through the buffer
 func setupTestBuffer(t *testing.T) func([]Message) []int64 {
+	limits := config.Default().Chunking
 	return func(messages []Message) []int64 {
 		var processedChunks []int64
 		msgBuffer := buffer.NewMessageBuffer()
@@ -66,9 +68,9 @@ func setupTestBuffer(t *testing.T) func([]Message) []int64 {
 
 				// 3. Check if buffer should be processed *now* (after adding)
 				shouldProcess := false
-				if currentSize > hardLimitChunkSize {
+				if currentSize > limits.HardLimit {
 					shouldProcess = true
-				} else if currentSize >= softLimitChunkSize && !timeProximity {
+				} else if currentSize >= limits.SoftLimit && !timeProximity {
 					// Process only if soft limit reached AND time proximity broken
 					// Requires buffer to have had content before this message (checked implicitly by lastTimestamp > 0)
 					shouldProcess = lastTimestamp > 0
@@ -226,3 +228,31 @@ func TestCombinedConditions(t *testing.T) {
 	assert.Equal(t, int64(5), processedChunks[1], "Second chunk due to hard limit")
 	assert.Equal(t, int64(7), processedChunks[2], "Third chunk for remaining messages")
 }
+
+func TestBotChatID(t *testing.T) {
+	tests := []struct {
+		chatType string
+		id       int64
+		want     int64
+	}{
+		{"private_group", 4696915167, -4696915167},
+		{"private_supergroup", 1234567890, -1001234567890},
+		{"public_supergroup", 1234567890, -1001234567890},
+		{"public_channel", 42, -1000000000042},
+		{"personal_chat", 987654, 987654},
+	}
+
+	for _, tt := range tests {
+		backup := TelegramBackup{Type: tt.chatType, ID: tt.id}
+		assert.Equal(t, tt.want, backup.BotChatID(), "chat type %s", tt.chatType)
+	}
+}
+
+func TestMessageReplyToID(t *testing.T) {
+	raw := `{"id": 12, "type": "message", "date_unixtime": "1744962615", "from": "user1", "from_id": "user87654321", "reply_to_message_id": 10, "text": "agreed"}`
+
+	var message Message
+	assert.NoError(t, json.Unmarshal([]byte(raw), &message))
+	assert.Equal(t, int64(10), message.ReplyToID)
+	assert.Equal(t, "user87654321", message.FromID)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/korjavin/ragtgbot/internal/buffer"
+	"github.com/korjavin/ragtgbot/internal/embedding"
+	"github.com/korjavin/ragtgbot/internal/outbox"
+	"github.com/korjavin/ragtgbot/internal/pointid"
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+)
+
+const (
+	defaultWorkers     = 4  // Concurrent embedding requests
+	defaultEmbedBatch  = 16 // Chunks per embedding request
+	defaultUpsertBatch = 64 // Points per Qdrant upsert
+)
+
+// pendingPoint is an embedded chunk waiting to be written to Qdrant
+type pendingPoint struct {
+	entry         outbox.Entry
+	point         qdrant.Point
+	messages      int   // Messages in the chunk, for the progress bar
+	lastMessageID int64 // Identifies the chunk to the checkpoint
+}
+
+// pipeline embeds chunks with a pool of workers and writes the points to
+// Qdrant in batches. Both channels are bounded, so Submit blocks once the
+// services fall behind instead of holding the whole export in memory.
+type pipeline struct {
+	store       *qdrant.Client
+	chatID      int64
+	embedBatch  int
+	upsertBatch int
+	embedder    embedding.Embedder
+	outbox      *outbox.Outbox // Keeps failed chunks for retrying, nil drops them
+	progress    *ChatProgress  // Moves the checkpoint as chunks are persisted, may be nil
+	bar         *pb.ProgressBar
+
+	chunks  chan buffer.Chunk
+	points  chan pendingPoint
+	workers sync.WaitGroup
+	done    chan struct{}
+
+	stored atomic.Int64 // Chunks written to Qdrant
+	failed atomic.Int64 // Chunks that failed with embedding or Qdrant errors
+	queued atomic.Int64 // Failed chunks kept in the outbox
+}
+
+func newPipeline(store *qdrant.Client, embedder embedding.Embedder, box *outbox.Outbox, progress *ChatProgress, chatID int64, workers, embedBatch, upsertBatch int, bar *pb.ProgressBar) *pipeline {
+	p := &pipeline{
+		store:       store,
+		chatID:      chatID,
+		embedBatch:  embedBatch,
+		upsertBatch: upsertBatch,
+		embedder:    embedder,
+		outbox:      box,
+		progress:    progress,
+		bar:         bar,
+		chunks:      make(chan buffer.Chunk, workers*embedBatch),
+		points:      make(chan pendingPoint, upsertBatch),
+		done:        make(chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		p.workers.Add(1)
+		go p.embedWorker()
+	}
+	go p.writer()
+	return p
+}
+
+// Submit queues a chunk for storage, blocking while the queue is full
+func (p *pipeline) Submit(chunk buffer.Chunk) {
+	if p.progress != nil {
+		p.progress.Submitted(chunk.LastMessageID)
+	}
+	p.chunks <- chunk
+}
+
+// Close waits until every submitted chunk has been stored or has failed
+func (p *pipeline) Close() {
+	close(p.chunks)
+	p.workers.Wait()
+	close(p.points)
+	<-p.done
+}
+
+func (p *pipeline) embedWorker() {
+	defer p.workers.Done()
+
+	for chunk := range p.chunks {
+		batch := []buffer.Chunk{chunk}
+		// Take whatever else is already queued, up to a full request
+	fill:
+		for len(batch) < p.embedBatch {
+			select {
+			case next, ok := <-p.chunks:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, next)
+			default:
+				break fill
+			}
+		}
+		p.embed(batch)
+	}
+}
+
+func (p *pipeline) embed(batch []buffer.Chunk) {
+	texts := make([]string, len(batch))
+	for i, chunk := range batch {
+		texts[i] = chunk.Text
+	}
+
+	embeddings, err := p.embedder.Embed(context.Background(), texts)
+	if err != nil {
+		fmt.Printf("Error getting embeddings for %d chunks: %v\n", len(batch), err)
+		for _, chunk := range batch {
+			p.fail(p.pending(chunk), err)
+		}
+		return
+	}
+
+	for i, chunk := range batch {
+		pending := p.pending(chunk)
+		pending.point = pending.entry.Point(embeddings[i], keywordSearchEnabled)
+		p.points <- pending
+	}
+}
+
+// pending describes a chunk on its way to Qdrant, before it is embedded
+func (p *pipeline) pending(chunk buffer.Chunk) pendingPoint {
+	return pendingPoint{
+		entry:         newEntry(p.chatID, chunk),
+		messages:      len(chunk.Messages),
+		lastMessageID: chunk.LastMessageID,
+	}
+}
+
+func (p *pipeline) writer() {
+	defer close(p.done)
+
+	batch := make([]pendingPoint, 0, p.upsertBatch)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		points := make([]qdrant.Point, len(batch))
+		for i, pending := range batch {
+			points[i] = pending.point
+		}
+
+		// Waiting for Qdrant to apply the batch keeps the import from outrunning indexing
+		if err := p.store.Upsert(context.Background(), collectionName, points, true); err != nil {
+			fmt.Printf("Error saving %d points to Qdrant: %v\n", len(batch), err)
+			for _, pending := range batch {
+				p.fail(pending, err)
+			}
+		} else {
+			p.stored.Add(int64(len(batch)))
+			ids := make([]int64, len(batch))
+			for i, pending := range batch {
+				p.bar.Add(pending.messages)
+				ids[i] = pending.lastMessageID
+			}
+			p.persisted(ids...)
+		}
+		batch = batch[:0]
+	}
+
+	for pending := range p.points {
+		batch = append(batch, pending)
+		if len(batch) >= p.upsertBatch {
+			flush()
+		}
+	}
+	flush()
+}
+
+// fail records a failed chunk and queues it in the outbox, if any; its
+// messages still count as done on the bar. Only a queued chunk lets the
+// checkpoint move past it.
+func (p *pipeline) fail(pending pendingPoint, cause error) {
+	p.failed.Add(1)
+	p.bar.Add(pending.messages)
+	if p.outbox == nil {
+		return
+	}
+	if err := p.outbox.Add(pending.entry, cause); err != nil {
+		fmt.Printf("Error queueing chunk %s in the outbox: %v\n", pending.entry.ID, err)
+		return
+	}
+	p.queued.Add(1)
+	p.persisted(pending.lastMessageID)
+}
+
+// persisted moves the checkpoint over chunks stored or queued in the outbox
+func (p *pipeline) persisted(lastMessageIDs ...int64) {
+	if p.progress == nil {
+		return
+	}
+	if err := p.progress.Persisted(lastMessageIDs...); err != nil {
+		fmt.Printf("Error saving checkpoint: %v\n", err)
+	}
+}
+
+// newEntry describes the Qdrant point of a chunk without its vectors. The
+// same chunk of the same chat always gets the same ID, so re-imports
+// overwrite.
+func newEntry(chatID int64, chunk buffer.Chunk) outbox.Entry {
+	return outbox.Entry{
+		ID:   qdrant.PointID(pointid.ForChunk(chatID, chunk.FirstMessageID, chunk.LastMessageID)),
+		Text: chunk.Text,
+		Payload: map[string]interface{}{
+			"text":             chunk.Text,
+			"username":         chunk.Username,
+			"chat_id":          strconv.FormatInt(chatID, 10),
+			"source":           sourceBackup,
+			"first_timestamp":  chunk.FirstDate(),
+			"last_timestamp":   chunk.LastDate(),
+			"first_message_id": chunk.FirstMessageID,
+			"last_message_id":  chunk.LastMessageID,
+			"participants":     chunk.Participants(),
+			"participant_ids":  chunk.ParticipantIDs(),
+		},
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/cheggaaa/pb/v3"
+	"github.com/korjavin/ragtgbot/internal/buffer"
+	"github.com/korjavin/ragtgbot/internal/outbox"
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeEmbedder returns the same two-dimensional vector for every text
+type fakeEmbedder struct {
+	onEmbed func(texts []string)
+	err     error
+}
+
+func (f *fakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.onEmbed != nil {
+		f.onEmbed(texts)
+	}
+	embeddings := make([][]float32, len(texts))
+	for i := range embeddings {
+		embeddings[i] = []float32{1, 0}
+	}
+	return embeddings, nil
+}
+
+func (f *fakeEmbedder) Dimension() int  { return 2 }
+func (f *fakeEmbedder) ModelID() string { return "fake" }
+
+func TestPipelineBatchesRequests(t *testing.T) {
+	var mutex sync.Mutex
+	var embedSizes, upsertSizes []int
+
+	embedder := &fakeEmbedder{onEmbed: func(texts []string) {
+		mutex.Lock()
+		embedSizes = append(embedSizes, len(texts))
+		mutex.Unlock()
+	}}
+
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Points []map[string]interface{} `json:"points"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mutex.Lock()
+		upsertSizes = append(upsertSizes, len(req.Points))
+		mutex.Unlock()
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	defer store.Close()
+
+	bar := pb.New(20)
+	p := newPipeline(qdrant.NewClient(store.URL, nil), embedder, nil, nil, -100, 2, 3, 4, bar)
+	for i := int64(0); i < 10; i++ {
+		p.Submit(buffer.Chunk{
+			Text:           "text",
+			FirstMessageID: 2*i + 1,
+			LastMessageID:  2*i + 2,
+			Messages:       []buffer.Message{{ID: 2*i + 1}, {ID: 2*i + 2}},
+		})
+	}
+	p.Close()
+
+	assert.Equal(t, int64(10), p.stored.Load())
+	assert.Equal(t, int64(0), p.failed.Load())
+	assert.Equal(t, int64(20), bar.Current())
+
+	total := 0
+	for _, size := range embedSizes {
+		assert.LessOrEqual(t, size, 3)
+		total += size
+	}
+	assert.Equal(t, 10, total)
+
+	total = 0
+	for _, size := range upsertSizes {
+		assert.LessOrEqual(t, size, 4)
+		total += size
+	}
+	assert.Equal(t, 10, total)
+}
+
+func TestPipelineCountsFailures(t *testing.T) {
+	embedder := &fakeEmbedder{err: errors.New("model not loaded")}
+
+	bar := pb.New(3)
+	box, err := outbox.Open(t.TempDir(), outbox.DefaultMaxAttempts)
+	assert.NoError(t, err)
+
+	p := newPipeline(qdrant.NewClient("http://127.0.0.1:0", nil), embedder, box, nil, -100, 1, 2, 2, bar)
+	p.Submit(buffer.Chunk{Text: "a", FirstMessageID: 1, LastMessageID: 2, Messages: []buffer.Message{{ID: 1}, {ID: 2}}})
+	p.Submit(buffer.Chunk{Text: "b", FirstMessageID: 3, LastMessageID: 3, Messages: []buffer.Message{{ID: 3}}})
+	p.Close()
+
+	assert.Equal(t, int64(0), p.stored.Load())
+	assert.Equal(t, int64(2), p.failed.Load())
+	assert.Equal(t, int64(3), bar.Current())
+
+	// Both chunks wait in the outbox, with the payload of their point
+	entries := box.Entries()
+	assert.Len(t, entries, 2)
+	assert.ElementsMatch(t, []string{"a", "b"}, []string{entries[0].Text, entries[1].Text})
+	assert.Equal(t, "model not loaded", entries[0].LastError)
+	assert.Equal(t, sourceBackup, entries[0].Payload["source"])
+}
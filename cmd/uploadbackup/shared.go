@@ -5,8 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
+
+	"github.com/korjavin/ragtgbot/internal/buffer"
 )
 
+// TelegramBackup is a chat of a Telegram Desktop export: the whole of a
+// single-chat result.json, or one entry of a full-account export's chat lists
 type TelegramBackup struct {
 	Name     string    `json:"name"`
 	Type     string    `json:"type"`
@@ -14,6 +19,19 @@ type TelegramBackup struct {
 	Messages []Message `json:"messages"`
 }
 
+// BotChatID converts the export's chat ID into the ID the Bot API (and thus
+// the live bot) uses for the same chat, so imported and live points match.
+func (b *TelegramBackup) BotChatID() int64 {
+	switch b.Type {
+	case "private_group":
+		return -b.ID
+	case "private_supergroup", "public_supergroup", "private_channel", "public_channel":
+		return -(1000000000000 + b.ID)
+	default:
+		return b.ID
+	}
+}
+
 type Message struct {
 	ID           int64           `json:"id"`
 	Type         string          `json:"type"`
@@ -25,6 +43,7 @@ type Message struct {
 	Actor        string          `json:"actor,omitempty"`
 	ActorID      string          `json:"actor_id,omitempty"`
 	Action       string          `json:"action,omitempty"`
+	ReplyToID    int64           `json:"reply_to_message_id,omitempty"`
 }
 
 // GetText extracts text from the message, handling plain strings and mixed arrays.
@@ -97,10 +116,8 @@ type EmbeddingResponse struct {
 }
 
 const (
-	maxChunkSize       = 3072     // Maximum characters in a chunk (old value, keeping for reference)
-	softLimitChunkSize = 1000     // Soft limit for chunk size
-	hardLimitChunkSize = 2000     // Hard limit for chunk size
-	timeProximityLimit = 3600 * 2 // Time proximity limit in seconds (2 hours, corrected from 24)
+	timeProximityLimit = int64(buffer.DefaultIdleTimeout / time.Second) // Time proximity limit in seconds (2 hours, shared with the bot's idle flush)
+	sourceBackup       = "backup"                                       // Payload source of points imported from a backup
 )
 
 // parseTimestamp converts a Unix timestamp string to int64
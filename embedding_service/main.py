@@ -1,4 +1,5 @@
 from fastapi import FastAPI, Request
+from fastapi.responses import JSONResponse
 from pydantic import BaseModel
 import uvicorn
 import json
@@ -34,13 +35,37 @@ try:
 except Exception as e:
     logger.error(f"Error loading model: {str(e)}")
 
+# Cross-encoder used to rerank search candidates
+reranker = None
+RERANK_MODEL_NAME = os.environ.get("RERANK_MODEL_NAME", "cross-encoder/mmarco-mMiniLMv2-L12-H384-v1")
+LOCAL_RERANK_MODEL_PATH = os.environ.get("LOCAL_RERANK_MODEL_PATH", "/app/models/mmarco-mMiniLMv2-L12-H384-v1")
+
+try:
+    from sentence_transformers import CrossEncoder
+
+    if os.path.exists(LOCAL_RERANK_MODEL_PATH):
+        logger.info(f"Loading rerank model from local path: {LOCAL_RERANK_MODEL_PATH}")
+        reranker = CrossEncoder(LOCAL_RERANK_MODEL_PATH)
+    else:
+        logger.info(f"Local rerank model not found. Downloading model: {RERANK_MODEL_NAME}")
+        reranker = CrossEncoder(RERANK_MODEL_NAME)
+    logger.info("Rerank model loaded successfully")
+except Exception as e:
+    logger.error(f"Error loading rerank model: {str(e)}")
+
 class TextList(BaseModel):
     texts: list[str]
 
+class RerankRequest(BaseModel):
+    query: str
+    documents: list[str]
+
 @app.post("/embeddings")
 async def get_embeddings(text_list: TextList):
     """
     Generates embeddings for a list of texts using the SentenceTransformer model.
+    Legacy format: a JSON string containing the list of embeddings, errors as HTTP 200.
+    New clients use /v2/embeddings.
     """
     if model is None:
         return {"error": "Model not initialized. Check server logs."}
@@ -52,10 +77,59 @@ async def get_embeddings(text_list: TextList):
         logger.error(f"Error generating embeddings: {str(e)}")
         return {"error": str(e)}
 
+@app.post("/v2/embeddings")
+async def get_embeddings_v2(text_list: TextList):
+    """
+    Generates embeddings for a list of texts using the SentenceTransformer model.
+    Returns the model, the dimension and one embedding per text, in order.
+    """
+    if model is None:
+        return JSONResponse(status_code=503, content={"error": "Model not initialized. Check server logs."})
+
+    dimension = model.get_sentence_embedding_dimension()
+    if not text_list.texts:
+        return {"model": MODEL_NAME, "dimension": dimension, "embeddings": []}
+
+    try:
+        embeddings = model.encode(text_list.texts)
+        return {"model": MODEL_NAME, "dimension": dimension, "embeddings": embeddings.tolist()}
+    except Exception as e:
+        logger.error(f"Error generating embeddings: {str(e)}")
+        return JSONResponse(status_code=500, content={"error": str(e)})
+
+@app.get("/info")
+async def info():
+    """
+    Describes the embedding model, so clients can size and check their vector collection.
+    """
+    if model is None:
+        return JSONResponse(status_code=503, content={"error": "Model not initialized. Check server logs."})
+
+    return {"model": MODEL_NAME, "dimension": model.get_sentence_embedding_dimension()}
+
+@app.post("/rerank")
+async def rerank(request: RerankRequest):
+    """
+    Scores how well each document answers the query using the cross-encoder.
+    Returns one score per document, in the order given; higher is better.
+    """
+    if reranker is None:
+        return JSONResponse(status_code=503, content={"error": "Rerank model not initialized. Check server logs."})
+
+    if not request.documents:
+        return {"scores": []}
+
+    try:
+        scores = reranker.predict([(request.query, document) for document in request.documents])
+        return {"scores": [float(score) for score in scores]}
+    except Exception as e:
+        logger.error(f"Error reranking documents: {str(e)}")
+        return JSONResponse(status_code=500, content={"error": str(e)})
+
 @app.get("/health")
 async def health_check():
     """Health check endpoint to verify the service is running."""
-    return {"status": "ok", "model_loaded": model is not None}
+    return {"status": "ok", "model_loaded": model is not None, "rerank_model_loaded": reranker is not None}
 
 if __name__ == "__main__":
     uvicorn.run(app, host="0.0.0.0", port=8000)
\ No newline at end of file
@@ -3,14 +3,100 @@ package buffer
 import (
 	"fmt"
 	"sync"
+	"time"
 )
 
+const (
+	// DefaultIdleTimeout is how long a conversation may be quiet before its
+	// buffer is considered finished (same 2 hour window the backup importer uses)
+	DefaultIdleTimeout = 2 * time.Hour
+	// DefaultMaxAge is the longest a message may wait in a buffer
+	DefaultMaxAge = 24 * time.Hour
+)
+
+// Message is a single chat message as added to a buffer
+type Message struct {
+	ID        int64 // Telegram message ID, 0 if unknown
+	Username  string
+	FromID    string // Sender ID in Telegram export form, e.g. "user12345678"
+	Text      string
+	Date      int64 // Unix time the message was sent, 0 if unknown
+	ReplyToID int64 // ID of the message this one replies to, 0 if none
+}
+
 // MessageBuffer stores messages until they're ready for processing
 type MessageBuffer struct {
-	Text     string
-	Username string
-	Size     int
-	mutex    sync.Mutex
+	Text           string
+	Username       string
+	Size           int
+	FirstTime      time.Time // When the first message was added
+	LastTime       time.Time // When the latest message was added
+	FirstMessageID int64     // Lowest Telegram message ID in the buffer, 0 if unknown
+	LastMessageID  int64     // Highest Telegram message ID in the buffer
+	Messages       []Message // Metadata of every buffered message, in the order added
+	firstSeq       uint64    // WAL sequence number of the first message, 0 without a WAL
+	lastSeq        uint64    // WAL sequence number of the latest message
+	mutex          sync.Mutex
+}
+
+// Chunk is the content of a buffer taken for processing
+type Chunk struct {
+	Text           string
+	Username       string
+	Size           int
+	FirstMessageID int64 // Telegram message ID range covered by the chunk
+	LastMessageID  int64
+	Messages       []Message
+	FirstSeq       uint64 // WAL sequence range covered by the chunk, zero without a WAL
+	LastSeq        uint64
+}
+
+// FirstDate returns the earliest message date in the chunk, 0 if unknown
+func (c Chunk) FirstDate() int64 {
+	var first int64
+	for _, m := range c.Messages {
+		if m.Date != 0 && (first == 0 || m.Date < first) {
+			first = m.Date
+		}
+	}
+	return first
+}
+
+// LastDate returns the latest message date in the chunk, 0 if unknown
+func (c Chunk) LastDate() int64 {
+	var last int64
+	for _, m := range c.Messages {
+		if m.Date > last {
+			last = m.Date
+		}
+	}
+	return last
+}
+
+// Participants returns the distinct usernames of the chunk in order of
+// their first message
+func (c Chunk) Participants() []string {
+	return distinct(c.Messages, func(m Message) string { return m.Username })
+}
+
+// ParticipantIDs returns the distinct sender IDs of the chunk in order of
+// their first message
+func (c Chunk) ParticipantIDs() []string {
+	return distinct(c.Messages, func(m Message) string { return m.FromID })
+}
+
+func distinct(messages []Message, field func(Message) string) []string {
+	seen := make(map[string]bool)
+	values := []string{}
+	for _, m := range messages {
+		v := field(m)
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		values = append(values, v)
+	}
+	return values
 }
 
 // NewMessageBuffer creates a new MessageBuffer
@@ -20,16 +106,46 @@ func NewMessageBuffer() *MessageBuffer {
 
 // Add adds a message to the buffer
 func (b *MessageBuffer) Add(username, text string) {
+	b.add(Message{Username: username, Text: text}, time.Now(), 0)
+}
+
+// AddMessage adds a message together with its Telegram metadata to the buffer
+func (b *MessageBuffer) AddMessage(msg Message) {
+	b.add(msg, time.Now(), 0)
+}
+
+func (b *MessageBuffer) add(msg Message, now time.Time, seq uint64) {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
+	username, text := msg.Username, msg.Text
+	if msg.ID != 0 {
+		if b.FirstMessageID == 0 || msg.ID < b.FirstMessageID {
+			b.FirstMessageID = msg.ID
+		}
+		if msg.ID > b.LastMessageID {
+			b.LastMessageID = msg.ID
+		}
+	}
+
+	if seq != 0 {
+		if b.firstSeq == 0 || seq < b.firstSeq {
+			b.firstSeq = seq
+		}
+		if seq > b.lastSeq {
+			b.lastSeq = seq
+		}
+	}
 	if b.Text == "" {
 		b.Username = username // Set username from first message
 		b.Text = fmt.Sprintf("%s: %s", username, text)
+		b.FirstTime = now
 	} else {
 		b.Text += fmt.Sprintf("\n%s: %s", username, text)
 	}
+	b.LastTime = now
 	b.Size += len(text)
+	b.Messages = append(b.Messages, msg)
 }
 
 // Clear resets the buffer
@@ -37,9 +153,54 @@ func (b *MessageBuffer) Clear() {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
+	b.reset()
+}
+
+func (b *MessageBuffer) reset() {
 	b.Text = ""
 	b.Username = ""
 	b.Size = 0
+	b.FirstTime = time.Time{}
+	b.LastTime = time.Time{}
+	b.FirstMessageID = 0
+	b.LastMessageID = 0
+	b.Messages = nil
+	b.firstSeq = 0
+	b.lastSeq = 0
+}
+
+// restore puts a taken chunk back in front of the messages added since.
+// The buffer counts as started at now, so an expired chunk is not retried
+// before the idle timeout has passed again.
+func (b *MessageBuffer) restore(chunk Chunk, now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if chunk.Size == 0 {
+		return
+	}
+	if b.Text == "" {
+		b.Text = chunk.Text
+		b.LastTime = now
+	} else {
+		b.Text = chunk.Text + "\n" + b.Text
+	}
+	b.Username = chunk.Username
+	b.FirstTime = now
+	b.Size += chunk.Size
+	if chunk.FirstMessageID != 0 && (b.FirstMessageID == 0 || chunk.FirstMessageID < b.FirstMessageID) {
+		b.FirstMessageID = chunk.FirstMessageID
+	}
+	if chunk.LastMessageID > b.LastMessageID {
+		b.LastMessageID = chunk.LastMessageID
+	}
+	if chunk.FirstSeq != 0 && (b.firstSeq == 0 || chunk.FirstSeq < b.firstSeq) {
+		b.firstSeq = chunk.FirstSeq
+	}
+	if chunk.LastSeq > b.lastSeq {
+		b.lastSeq = chunk.LastSeq
+	}
+	b.Messages = append(append([]Message(nil), chunk.Messages...), b.Messages...)
 }
 
 // IsEmpty returns true if the buffer is empty
@@ -57,3 +218,58 @@ func (b *MessageBuffer) GetContents() (string, string, int) {
 
 	return b.Text, b.Username, b.Size
 }
+
+// Take returns the buffer contents and clears the buffer in one step, so
+// messages added while the contents are being processed are not lost
+func (b *MessageBuffer) Take() (string, string, int) {
+	chunk := b.takeChunk()
+	return chunk.Text, chunk.Username, chunk.Size
+}
+
+// Snapshot returns the buffer contents as a chunk without clearing the buffer
+func (b *MessageBuffer) Snapshot() Chunk {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	chunk := b.chunk()
+	chunk.Messages = append([]Message(nil), b.Messages...)
+	return chunk
+}
+
+func (b *MessageBuffer) takeChunk() Chunk {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	chunk := b.chunk()
+	b.reset()
+	return chunk
+}
+
+func (b *MessageBuffer) chunk() Chunk {
+	return Chunk{
+		Text:           b.Text,
+		Username:       b.Username,
+		Size:           b.Size,
+		FirstMessageID: b.FirstMessageID,
+		LastMessageID:  b.LastMessageID,
+		Messages:       b.Messages,
+		FirstSeq:       b.firstSeq,
+		LastSeq:        b.lastSeq,
+	}
+}
+
+// Expired reports whether a non-empty buffer has been quiet for longer than
+// idleTimeout or has held its first message for longer than maxAge.
+// A zero duration disables the corresponding check.
+func (b *MessageBuffer) Expired(now time.Time, idleTimeout, maxAge time.Duration) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.Size == 0 {
+		return false
+	}
+	if idleTimeout > 0 && now.Sub(b.LastTime) >= idleTimeout {
+		return true
+	}
+	return maxAge > 0 && now.Sub(b.FirstTime) >= maxAge
+}
@@ -3,6 +3,7 @@ package buffer
 import (
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestNewMessageBuffer(t *testing.T) {
@@ -127,3 +128,111 @@ func TestMessageBuffer_Concurrency(t *testing.T) {
 		t.Errorf("Buffer size after concurrent additions = %d, want %d", size, expectedSize)
 	}
 }
+
+func TestMessageBuffer_Take(t *testing.T) {
+	buffer := NewMessageBuffer()
+	buffer.Add("user", "hello")
+
+	text, username, size := buffer.Take()
+	if text != "user: hello" || username != "user" || size != 5 {
+		t.Errorf("Take = (%q, %q, %d), want (%q, %q, %d)", text, username, size, "user: hello", "user", 5)
+	}
+	if !buffer.IsEmpty() {
+		t.Error("Buffer should be empty after Take")
+	}
+	if !buffer.FirstTime.IsZero() || !buffer.LastTime.IsZero() {
+		t.Error("Take should reset the buffer timestamps")
+	}
+}
+
+func TestMessageBuffer_Expired(t *testing.T) {
+	buffer := NewMessageBuffer()
+	now := time.Now()
+
+	if buffer.Expired(now.Add(time.Hour), time.Minute, time.Minute) {
+		t.Error("Empty buffer should never expire")
+	}
+
+	buffer.Add("user", "hello")
+	buffer.FirstTime = now.Add(-30 * time.Minute)
+	buffer.LastTime = now.Add(-5 * time.Minute)
+
+	if buffer.Expired(now, 10*time.Minute, time.Hour) {
+		t.Error("Buffer should not expire before idle timeout and max age")
+	}
+	if !buffer.Expired(now, 5*time.Minute, time.Hour) {
+		t.Error("Buffer should expire after the idle timeout")
+	}
+	if !buffer.Expired(now, 10*time.Minute, 30*time.Minute) {
+		t.Error("Buffer should expire after the max age")
+	}
+	if buffer.Expired(now, 0, 0) {
+		t.Error("Zero durations should disable expiry")
+	}
+}
+
+func TestMessageBuffer_MessageIDRange(t *testing.T) {
+	buffer := NewMessageBuffer()
+	buffer.AddMessage(Message{ID: 12, Username: "user1", Text: "hello"})
+	buffer.AddMessage(Message{ID: 10, Username: "user2", Text: "late delivery"})
+	buffer.AddMessage(Message{ID: 15, Username: "user1", Text: "bye"})
+	buffer.Add("user3", "no id")
+
+	if buffer.FirstMessageID != 10 || buffer.LastMessageID != 15 {
+		t.Errorf("Message ID range = %d-%d, want 10-15", buffer.FirstMessageID, buffer.LastMessageID)
+	}
+
+	chunk := buffer.takeChunk()
+	if chunk.FirstMessageID != 10 || chunk.LastMessageID != 15 {
+		t.Errorf("Chunk message ID range = %d-%d, want 10-15", chunk.FirstMessageID, chunk.LastMessageID)
+	}
+	if buffer.FirstMessageID != 0 || buffer.LastMessageID != 0 {
+		t.Error("Taking the chunk should reset the message ID range")
+	}
+}
+
+func TestChunk_Metadata(t *testing.T) {
+	buffer := NewMessageBuffer()
+	buffer.AddMessage(Message{ID: 1, Username: "alice", FromID: "user1", Text: "hi", Date: 1700000100})
+	buffer.AddMessage(Message{ID: 2, Username: "bob", FromID: "user2", Text: "hello", Date: 1700000050, ReplyToID: 1})
+	buffer.AddMessage(Message{ID: 3, Username: "alice", FromID: "user1", Text: "bye", Date: 1700000200})
+
+	chunk := buffer.Snapshot()
+	if buffer.IsEmpty() {
+		t.Error("Snapshot should not clear the buffer")
+	}
+	if len(chunk.Messages) != 3 || chunk.Messages[1].ReplyToID != 1 {
+		t.Errorf("Chunk messages = %+v", chunk.Messages)
+	}
+	if chunk.FirstDate() != 1700000050 || chunk.LastDate() != 1700000200 {
+		t.Errorf("Chunk dates = %d-%d, want 1700000050-1700000200", chunk.FirstDate(), chunk.LastDate())
+	}
+
+	participants := chunk.Participants()
+	if len(participants) != 2 || participants[0] != "alice" || participants[1] != "bob" {
+		t.Errorf("Participants = %v, want [alice bob]", participants)
+	}
+	ids := chunk.ParticipantIDs()
+	if len(ids) != 2 || ids[0] != "user1" || ids[1] != "user2" {
+		t.Errorf("ParticipantIDs = %v, want [user1 user2]", ids)
+	}
+
+	// The snapshot must not alias the buffer
+	buffer.AddMessage(Message{ID: 4, Username: "carol", Text: "late"})
+	if len(chunk.Messages) != 3 {
+		t.Error("Adding to the buffer should not change an earlier snapshot")
+	}
+}
+
+func TestChunk_MetadataWithoutDates(t *testing.T) {
+	buffer := NewMessageBuffer()
+	buffer.Add("user", "hello")
+
+	chunk := buffer.Snapshot()
+	if chunk.FirstDate() != 0 || chunk.LastDate() != 0 {
+		t.Errorf("Chunk dates = %d-%d, want 0-0", chunk.FirstDate(), chunk.LastDate())
+	}
+	if ids := chunk.ParticipantIDs(); len(ids) != 0 {
+		t.Errorf("ParticipantIDs = %v, want none", ids)
+	}
+}
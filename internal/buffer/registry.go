@@ -0,0 +1,143 @@
+package buffer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Key identifies a conversation: a chat and, for forum groups, a topic
+type Key struct {
+	ChatID   int64
+	ThreadID int
+}
+
+// String returns a human-readable form of the key for logging
+func (k Key) String() string {
+	if k.ThreadID == 0 {
+		return fmt.Sprintf("%d", k.ChatID)
+	}
+	return fmt.Sprintf("%d/%d", k.ChatID, k.ThreadID)
+}
+
+// Registry keeps a separate MessageBuffer for every conversation
+type Registry struct {
+	buffers map[Key]*MessageBuffer
+	wal     *WAL       // Optional, nil keeps buffers in memory only
+	walLock sync.Mutex // Keeps WAL order and buffer order in step
+	mutex   sync.Mutex
+}
+
+// NewRegistry creates a new, empty Registry
+func NewRegistry() *Registry {
+	return &Registry{buffers: make(map[Key]*MessageBuffer)}
+}
+
+// NewRegistryWithWAL creates a Registry backed by a write-ahead log at
+// walPath. Messages left in the log by a previous run are replayed into
+// their buffers.
+func NewRegistryWithWAL(walPath string) (*Registry, error) {
+	wal, err := OpenWAL(walPath)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewRegistry()
+	r.wal = wal
+	for _, entry := range wal.Entries() {
+		r.Get(entry.Key()).add(entry.Message(), time.Unix(0, entry.Time), entry.Seq)
+	}
+	return r, nil
+}
+
+// Add records the message in the WAL, if any, and adds it to the buffer of
+// the conversation. The message is buffered even if writing the WAL fails.
+func (r *Registry) Add(key Key, msg Message) error {
+	r.walLock.Lock()
+	defer r.walLock.Unlock()
+
+	now := time.Now()
+	var seq uint64
+	if r.wal != nil {
+		var err error
+		seq, err = r.wal.Append(WALEntry{
+			ChatID:    key.ChatID,
+			ThreadID:  key.ThreadID,
+			MessageID: msg.ID,
+			Username:  msg.Username,
+			FromID:    msg.FromID,
+			Text:      msg.Text,
+			Date:      msg.Date,
+			ReplyToID: msg.ReplyToID,
+			Time:      now.UnixNano(),
+		})
+		if err != nil {
+			// Still buffer the message, it just won't survive a crash
+			r.Get(key).add(msg, now, 0)
+			return err
+		}
+	}
+
+	r.Get(key).add(msg, now, seq)
+	return nil
+}
+
+// Take returns the contents of the conversation's buffer and clears it.
+// Call Commit with the chunk once it has been stored.
+func (r *Registry) Take(key Key) Chunk {
+	return r.Get(key).takeChunk()
+}
+
+// Restore puts a taken chunk that could not be stored back in front of the
+// conversation's buffer, so its messages are stored with the next chunk
+func (r *Registry) Restore(key Key, chunk Chunk) {
+	r.Get(key).restore(chunk, time.Now())
+}
+
+// Commit removes the messages of a stored chunk from the WAL
+func (r *Registry) Commit(key Key, chunk Chunk) error {
+	if r.wal == nil || chunk.FirstSeq == 0 {
+		return nil
+	}
+	return r.wal.Remove(key, chunk.FirstSeq, chunk.LastSeq)
+}
+
+// Close closes the WAL, if any
+func (r *Registry) Close() error {
+	if r.wal == nil {
+		return nil
+	}
+	return r.wal.Close()
+}
+
+// Get returns the buffer for the given key, creating it if needed
+func (r *Registry) Get(key Key) *MessageBuffer {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	b, ok := r.buffers[key]
+	if !ok {
+		b = NewMessageBuffer()
+		r.buffers[key] = b
+	}
+	return b
+}
+
+// Keys returns the keys of all known buffers in a stable order
+func (r *Registry) Keys() []Key {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	keys := make([]Key, 0, len(r.buffers))
+	for k := range r.buffers {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ChatID != keys[j].ChatID {
+			return keys[i].ChatID < keys[j].ChatID
+		}
+		return keys[i].ThreadID < keys[j].ThreadID
+	})
+	return keys
+}
@@ -0,0 +1,91 @@
+package buffer
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRegistry_GetReturnsSameBuffer(t *testing.T) {
+	registry := NewRegistry()
+	key := Key{ChatID: -100123}
+
+	first := registry.Get(key)
+	second := registry.Get(key)
+	if first != second {
+		t.Error("Get should return the same buffer for the same key")
+	}
+}
+
+func TestRegistry_BuffersAreIsolated(t *testing.T) {
+	registry := NewRegistry()
+	groupA := Key{ChatID: -1001}
+	groupB := Key{ChatID: -1002}
+	topic := Key{ChatID: -1001, ThreadID: 7}
+
+	registry.Get(groupA).Add("alice", "secret plans")
+	registry.Get(groupB).Add("bob", "lunch")
+
+	text, _, _ := registry.Get(groupA).GetContents()
+	if text != "alice: secret plans" {
+		t.Errorf("Buffer A text = %q, want %q", text, "alice: secret plans")
+	}
+	text, _, _ = registry.Get(groupB).GetContents()
+	if text != "bob: lunch" {
+		t.Errorf("Buffer B text = %q, want %q", text, "bob: lunch")
+	}
+	if !registry.Get(topic).IsEmpty() {
+		t.Error("Forum topic buffer should be separate from the main chat buffer")
+	}
+}
+
+func TestRegistry_Keys(t *testing.T) {
+	registry := NewRegistry()
+	registry.Get(Key{ChatID: 5, ThreadID: 2})
+	registry.Get(Key{ChatID: -3})
+	registry.Get(Key{ChatID: 5})
+
+	keys := registry.Keys()
+	want := []Key{{ChatID: -3}, {ChatID: 5}, {ChatID: 5, ThreadID: 2}}
+	if len(keys) != len(want) {
+		t.Fatalf("Keys returned %d keys, want %d", len(keys), len(want))
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Errorf("Keys()[%d] = %v, want %v", i, keys[i], want[i])
+		}
+	}
+}
+
+func TestKey_String(t *testing.T) {
+	if got := (Key{ChatID: -100}).String(); got != "-100" {
+		t.Errorf("Key.String() = %q, want %q", got, "-100")
+	}
+	if got := (Key{ChatID: -100, ThreadID: 4}).String(); got != "-100/4" {
+		t.Errorf("Key.String() = %q, want %q", got, "-100/4")
+	}
+}
+
+func TestRegistry_Concurrency(t *testing.T) {
+	registry := NewRegistry()
+	const numChats = 20
+	const messagesPerChat = 50
+
+	var wg sync.WaitGroup
+	wg.Add(numChats)
+	for i := 0; i < numChats; i++ {
+		go func(chatID int64) {
+			defer wg.Done()
+			for j := 0; j < messagesPerChat; j++ {
+				registry.Get(Key{ChatID: chatID}).Add("user", "a")
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+
+	for _, key := range registry.Keys() {
+		_, _, size := registry.Get(key).GetContents()
+		if size != messagesPerChat {
+			t.Errorf("Buffer %v size = %d, want %d", key, size, messagesPerChat)
+		}
+	}
+}
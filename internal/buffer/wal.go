@@ -0,0 +1,218 @@
+package buffer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// WALEntry is a single buffered message as recorded in the write-ahead log
+type WALEntry struct {
+	Seq       uint64 `json:"seq"`
+	ChatID    int64  `json:"chat_id"`
+	ThreadID  int    `json:"thread_id,omitempty"`
+	MessageID int64  `json:"message_id,omitempty"`
+	Username  string `json:"username"`
+	FromID    string `json:"from_id,omitempty"`
+	Text      string `json:"text"`
+	Date      int64  `json:"date,omitempty"`
+	ReplyToID int64  `json:"reply_to_id,omitempty"`
+	Time      int64  `json:"time"` // Unix time in nanoseconds when the message was added
+}
+
+// Key returns the conversation the entry belongs to
+func (e WALEntry) Key() Key {
+	return Key{ChatID: e.ChatID, ThreadID: e.ThreadID}
+}
+
+// Message returns the buffered message recorded by the entry
+func (e WALEntry) Message() Message {
+	return Message{
+		ID:        e.MessageID,
+		Username:  e.Username,
+		FromID:    e.FromID,
+		Text:      e.Text,
+		Date:      e.Date,
+		ReplyToID: e.ReplyToID,
+	}
+}
+
+// WAL is an append-only file of messages that were buffered but not yet
+// stored. Entries are removed again once their chunk has been persisted.
+type WAL struct {
+	path    string
+	file    *os.File
+	pending []WALEntry
+	nextSeq uint64
+	mutex   sync.Mutex
+}
+
+// OpenWAL opens (or creates) the write-ahead log at path and loads the
+// entries that were not yet removed. A partially written last line, as left
+// behind by a crash in the middle of a write, is ignored and dropped from
+// the file, so that new entries are not appended onto it.
+func OpenWAL(path string) (*WAL, error) {
+	w := &WAL{path: path, nextSeq: 1}
+
+	exists, err := w.load()
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		// Rewrite the log from the entries read, which also reopens it
+		if err := w.rewrite(w.pending); err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening WAL %s: %v", path, err)
+	}
+	w.file = file
+	return w, nil
+}
+
+// load reads the entries of an existing log and reports whether there is one
+func (w *WAL) load() (bool, error) {
+	file, err := os.Open(w.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error opening WAL %s: %v", w.path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry WALEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // Torn write from a crash, the message never made it into the buffer
+		}
+		w.pending = append(w.pending, entry)
+		if entry.Seq >= w.nextSeq {
+			w.nextSeq = entry.Seq + 1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("error reading WAL %s: %v", w.path, err)
+	}
+	return true, nil
+}
+
+// Entries returns the entries that have not been removed yet, in the order
+// they were appended
+func (w *WAL) Entries() []WALEntry {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	entries := make([]WALEntry, len(w.pending))
+	copy(entries, w.pending)
+	return entries
+}
+
+// Append assigns the next sequence number to the entry and writes it to disk
+func (w *WAL) Append(entry WALEntry) (uint64, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	entry.Seq = w.nextSeq
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	line = append(line, '\n')
+
+	if _, err := w.file.Write(line); err != nil {
+		return 0, fmt.Errorf("error writing WAL entry: %v", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return 0, fmt.Errorf("error syncing WAL: %v", err)
+	}
+
+	w.nextSeq++
+	w.pending = append(w.pending, entry)
+	return entry.Seq, nil
+}
+
+// Remove drops the entries of the given conversation with sequence numbers
+// in [firstSeq, lastSeq] and rewrites the log without them
+func (w *WAL) Remove(key Key, firstSeq, lastSeq uint64) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	remaining := w.pending[:0:0]
+	for _, entry := range w.pending {
+		if entry.Key() == key && entry.Seq >= firstSeq && entry.Seq <= lastSeq {
+			continue
+		}
+		remaining = append(remaining, entry)
+	}
+	if len(remaining) == len(w.pending) {
+		return nil
+	}
+
+	if err := w.rewrite(remaining); err != nil {
+		return err
+	}
+	w.pending = remaining
+	return nil
+}
+
+// rewrite atomically replaces the log file with the given entries
+func (w *WAL) rewrite(entries []WALEntry) error {
+	tmp, err := os.CreateTemp(filepath.Dir(w.path), filepath.Base(w.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary WAL: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			tmp.Close()
+			return fmt.Errorf("error writing temporary WAL: %v", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing temporary WAL: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error syncing temporary WAL: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), w.path); err != nil {
+		return fmt.Errorf("error replacing WAL: %v", err)
+	}
+
+	// The old handle points at the replaced file, reopen for appending
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("error reopening WAL %s: %v", w.path, err)
+	}
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.file = file
+	return nil
+}
+
+// Close closes the underlying file
+func (w *WAL) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.file.Close()
+}
@@ -0,0 +1,214 @@
+package buffer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWAL_AppendAndReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffer.wal")
+
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatalf("OpenWAL failed: %v", err)
+	}
+	seq1, err := wal.Append(WALEntry{ChatID: -1, Username: "alice", Text: "hello"})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	seq2, err := wal.Append(WALEntry{ChatID: -2, Username: "bob", Text: "world"})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if seq1 != 1 || seq2 != 2 {
+		t.Errorf("Sequence numbers = %d, %d, want 1, 2", seq1, seq2)
+	}
+	wal.Close()
+
+	wal, err = OpenWAL(path)
+	if err != nil {
+		t.Fatalf("Reopening WAL failed: %v", err)
+	}
+	defer wal.Close()
+
+	entries := wal.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Reopened WAL has %d entries, want 2", len(entries))
+	}
+	if entries[0].Text != "hello" || entries[1].Username != "bob" {
+		t.Errorf("Unexpected entries after reopen: %+v", entries)
+	}
+
+	seq3, err := wal.Append(WALEntry{ChatID: -1, Username: "alice", Text: "again"})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if seq3 != 3 {
+		t.Errorf("Sequence after reopen = %d, want 3", seq3)
+	}
+}
+
+func TestWAL_Remove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffer.wal")
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatalf("OpenWAL failed: %v", err)
+	}
+
+	wal.Append(WALEntry{ChatID: -1, Text: "a"})
+	wal.Append(WALEntry{ChatID: -2, Text: "b"})
+	wal.Append(WALEntry{ChatID: -1, Text: "c"})
+	wal.Append(WALEntry{ChatID: -1, Text: "d"})
+
+	if err := wal.Remove(Key{ChatID: -1}, 1, 3); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	wal.Close()
+
+	wal, err = OpenWAL(path)
+	if err != nil {
+		t.Fatalf("Reopening WAL failed: %v", err)
+	}
+	defer wal.Close()
+
+	entries := wal.Entries()
+	if len(entries) != 2 || entries[0].Text != "b" || entries[1].Text != "d" {
+		t.Errorf("Entries after Remove = %+v, want b and d", entries)
+	}
+}
+
+func TestWAL_IgnoresTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffer.wal")
+	content := `{"seq":1,"chat_id":-1,"username":"alice","text":"hello","time":1}` + "\n" + `{"seq":2,"chat_id":-1,"user`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatalf("OpenWAL failed: %v", err)
+	}
+
+	entries := wal.Entries()
+	if len(entries) != 1 || entries[0].Text != "hello" {
+		t.Errorf("Entries = %+v, want only the complete entry", entries)
+	}
+
+	// The next entry is not appended onto the torn line
+	if _, err := wal.Append(WALEntry{ChatID: -1, Username: "bob", Text: "after"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	wal.Close()
+
+	wal, err = OpenWAL(path)
+	if err != nil {
+		t.Fatalf("Reopening WAL failed: %v", err)
+	}
+	defer wal.Close()
+
+	entries = wal.Entries()
+	if len(entries) != 2 || entries[0].Text != "hello" || entries[1].Text != "after" {
+		t.Errorf("Entries after reopening = %+v, want hello and after", entries)
+	}
+}
+
+func TestRegistry_WALReplayAndCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffer.wal")
+	chatA := Key{ChatID: -1}
+	chatB := Key{ChatID: -2, ThreadID: 3}
+
+	registry, err := NewRegistryWithWAL(path)
+	if err != nil {
+		t.Fatalf("NewRegistryWithWAL failed: %v", err)
+	}
+	registry.Add(chatA, Message{Username: "alice", Text: "hello"})
+	registry.Add(chatB, Message{Username: "bob", Text: "topic message"})
+	registry.Add(chatA, Message{Username: "carol", Text: "hi"})
+
+	chunk := registry.Take(chatA)
+	if chunk.Text != "alice: hello\ncarol: hi" {
+		t.Errorf("Chunk text = %q", chunk.Text)
+	}
+	if err := registry.Commit(chatA, chunk); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	// Simulate a crash: chat B was never stored
+	registry.Close()
+
+	registry, err = NewRegistryWithWAL(path)
+	if err != nil {
+		t.Fatalf("NewRegistryWithWAL failed: %v", err)
+	}
+	defer registry.Close()
+
+	if !registry.Get(chatA).IsEmpty() {
+		t.Error("Committed chat should not be replayed")
+	}
+	text, username, _ := registry.Get(chatB).GetContents()
+	if text != "bob: topic message" || username != "bob" {
+		t.Errorf("Replayed buffer = (%q, %q), want (%q, %q)", text, username, "bob: topic message", "bob")
+	}
+}
+
+func TestRegistry_TakeWithoutCommitIsReplayed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffer.wal")
+	chat := Key{ChatID: -1}
+
+	registry, err := NewRegistryWithWAL(path)
+	if err != nil {
+		t.Fatalf("NewRegistryWithWAL failed: %v", err)
+	}
+	registry.Add(chat, Message{Username: "alice", Text: "hello"})
+	registry.Take(chat) // Processing failed, nothing committed
+	registry.Close()
+
+	registry, err = NewRegistryWithWAL(path)
+	if err != nil {
+		t.Fatalf("NewRegistryWithWAL failed: %v", err)
+	}
+	defer registry.Close()
+
+	if registry.Get(chat).IsEmpty() {
+		t.Error("Uncommitted messages should be replayed")
+	}
+}
+
+func TestRegistry_RestoreFailedChunk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffer.wal")
+	chat := Key{ChatID: -1}
+
+	registry, err := NewRegistryWithWAL(path)
+	if err != nil {
+		t.Fatalf("NewRegistryWithWAL failed: %v", err)
+	}
+	registry.Add(chat, Message{ID: 1, Username: "alice", Text: "hello"})
+	chunk := registry.Take(chat)
+	registry.Add(chat, Message{ID: 2, Username: "bob", Text: "hi"}) // Arrives while storing
+
+	// Storing failed: the chunk goes back in front of the newer message
+	registry.Restore(chat, chunk)
+	retry := registry.Take(chat)
+	if retry.Text != "alice: hello\nbob: hi" || retry.Username != "alice" || len(retry.Messages) != 2 {
+		t.Errorf("Chunk after Restore = %+v", retry)
+	}
+	if retry.FirstMessageID != 1 || retry.LastMessageID != 2 {
+		t.Errorf("Message range after Restore = %d-%d, want 1-2", retry.FirstMessageID, retry.LastMessageID)
+	}
+
+	// Committing the retried chunk removes both messages from the WAL
+	if err := registry.Commit(chat, retry); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	registry.Close()
+	registry, err = NewRegistryWithWAL(path)
+	if err != nil {
+		t.Fatalf("NewRegistryWithWAL failed: %v", err)
+	}
+	defer registry.Close()
+
+	if !registry.Get(chat).IsEmpty() {
+		t.Error("Committed messages should not be replayed")
+	}
+}
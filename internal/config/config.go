@@ -0,0 +1,333 @@
+// Package config holds the settings shared by the bot and the backup
+// importer. Defaults are overridden by an optional YAML or TOML file, which
+// is overridden by environment variables, and the result is validated as a
+// whole so every mistake is reported at startup.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/korjavin/ragtgbot/internal/buffer"
+	"github.com/korjavin/ragtgbot/internal/embedding"
+	"github.com/korjavin/ragtgbot/internal/outbox"
+	"github.com/korjavin/ragtgbot/internal/rank"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the environment variable with the path of the config file,
+// used when no path is passed on the command line
+const FileEnv = "CONFIG_FILE"
+
+// Rerankers selectable with Search.Reranker
+const (
+	RerankerCrossEncoder = "cross-encoder" // Embedding service's /rerank, lexical fallback
+	RerankerLexical      = "lexical"
+	RerankerNone         = "none"
+)
+
+// Config is the complete configuration. Field comments name the key in the
+// config file; the environment variables are listed in bindings.
+type Config struct {
+	Telegram  Telegram  `yaml:"telegram" toml:"telegram"`
+	OpenAI    OpenAI    `yaml:"openai" toml:"openai"`
+	Qdrant    Qdrant    `yaml:"qdrant" toml:"qdrant"`
+	Embedding Embedding `yaml:"embedding" toml:"embedding"`
+	Search    Search    `yaml:"search" toml:"search"`
+	Chunking  Chunking  `yaml:"chunking" toml:"chunking"`
+	Buffer    Buffer    `yaml:"buffer" toml:"buffer"`
+	Outbox    Outbox    `yaml:"outbox" toml:"outbox"`
+	Metrics   Metrics   `yaml:"metrics" toml:"metrics"`
+}
+
+// Telegram configures the bot's connection and the chats it serves
+type Telegram struct {
+	Token    string  `yaml:"token" toml:"token"`
+	Groups   []int64 `yaml:"groups" toml:"groups"`     // Allowed chats, empty allows all
+	Timezone string  `yaml:"timezone" toml:"timezone"` // For dates in questions, empty is UTC
+}
+
+// Location returns the chat time zone
+func (t Telegram) Location() (*time.Location, error) {
+	if t.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(t.Timezone)
+}
+
+// OpenAI configures the chat completions that write the answers
+type OpenAI struct {
+	APIKey  string        `yaml:"api_key" toml:"api_key"`
+	URL     string        `yaml:"url" toml:"url"`
+	Model   string        `yaml:"model" toml:"model"`
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"` // Per request attempt
+}
+
+// Qdrant configures the vector database
+type Qdrant struct {
+	Address    string        `yaml:"address" toml:"address"`
+	Collection string        `yaml:"collection" toml:"collection"` // Alias both binaries use
+	Timeout    time.Duration `yaml:"timeout" toml:"timeout"`       // Per request attempt
+}
+
+// Embedding configures the embedding backend and its cache
+type Embedding struct {
+	Backend   string        `yaml:"backend" toml:"backend"`
+	Address   string        `yaml:"address" toml:"address"` // Empty uses the backend's default
+	Model     string        `yaml:"model" toml:"model"`     // Empty uses the backend's default
+	APIKey    string        `yaml:"api_key" toml:"api_key"`
+	Timeout   time.Duration `yaml:"timeout" toml:"timeout"` // Per request attempt
+	CacheSize int           `yaml:"cache_size" toml:"cache_size"`
+	CachePath string        `yaml:"cache_path" toml:"cache_path"`
+}
+
+// EmbedderConfig returns the settings of embedding.New; the caller adds
+// the HTTP client
+func (e Embedding) EmbedderConfig(client *http.Client) embedding.Config {
+	return embedding.Config{
+		Backend:    e.Backend,
+		URL:        e.Address,
+		Model:      e.Model,
+		APIKey:     e.APIKey,
+		HTTPClient: client,
+	}
+}
+
+// CacheConfig returns the settings of embedding.NewCache
+func (e Embedding) CacheConfig() embedding.CacheConfig {
+	return embedding.CacheConfig{Size: e.CacheSize, Path: e.CachePath}
+}
+
+// Search configures how the bot finds the chunks for an answer
+type Search struct {
+	Limit          int           `yaml:"limit" toml:"limit"`                     // Chunks passed to OpenAI
+	CandidateLimit int           `yaml:"candidate_limit" toml:"candidate_limit"` // Fetched for reranking and MMR
+	Reranker       string        `yaml:"reranker" toml:"reranker"`
+	RerankAddress  string        `yaml:"rerank_address" toml:"rerank_address"` // Empty derives it from the embedding service
+	RerankTimeout  time.Duration `yaml:"rerank_timeout" toml:"rerank_timeout"`
+	MMRLambda      float64       `yaml:"mmr_lambda" toml:"mmr_lambda"` // Relevance vs. diversity, 1 disables MMR
+}
+
+// Chunking configures the size of the chunks messages are grouped into
+type Chunking struct {
+	MaxSize   int `yaml:"max_size" toml:"max_size"`     // Bot: characters that trigger a flush
+	SoftLimit int `yaml:"soft_limit" toml:"soft_limit"` // Import: flush at a time gap once reached
+	HardLimit int `yaml:"hard_limit" toml:"hard_limit"` // Import: always flush once reached
+}
+
+// Buffer configures the bot's message buffers
+type Buffer struct {
+	IdleTimeout time.Duration `yaml:"idle_timeout" toml:"idle_timeout"` // 0 disables
+	MaxAge      time.Duration `yaml:"max_age" toml:"max_age"`           // 0 disables
+	WALPath     string        `yaml:"wal_path" toml:"wal_path"`         // Empty keeps messages in memory only
+}
+
+// Outbox configures the queue of failed chunk writes
+type Outbox struct {
+	Dir         string `yaml:"dir" toml:"dir"` // Empty disables the outbox
+	MaxAttempts int    `yaml:"max_attempts" toml:"max_attempts"`
+}
+
+// Metrics configures the expvar endpoint
+type Metrics struct {
+	Address string `yaml:"address" toml:"address"` // Empty disables it
+}
+
+// Default returns the configuration used when nothing is set
+func Default() *Config {
+	return &Config{
+		OpenAI: OpenAI{
+			URL:     "https://api.openai.com/v1/chat/completions",
+			Model:   "gpt-4o-mini",
+			Timeout: 30 * time.Second,
+		},
+		Qdrant: Qdrant{
+			Address:    "http://localhost:6333",
+			Collection: "chat_history",
+			Timeout:    30 * time.Second,
+		},
+		Embedding: Embedding{
+			Backend:   embedding.BackendService,
+			Timeout:   time.Minute,
+			CacheSize: embedding.DefaultCacheSize,
+		},
+		Search: Search{
+			Limit:          5,
+			CandidateLimit: 50,
+			Reranker:       RerankerCrossEncoder,
+			RerankTimeout:  20 * time.Second,
+			MMRLambda:      rank.DefaultMMRLambda,
+		},
+		Chunking: Chunking{
+			MaxSize:   3072,
+			SoftLimit: 1000,
+			HardLimit: 2000,
+		},
+		Buffer: Buffer{
+			IdleTimeout: buffer.DefaultIdleTimeout,
+			MaxAge:      buffer.DefaultMaxAge,
+		},
+		Outbox: Outbox{MaxAttempts: outbox.DefaultMaxAttempts},
+	}
+}
+
+// Load returns the defaults overridden by the file at path, if not empty,
+// and then by the environment. The result is validated.
+func Load(path string) (*Config, error) {
+	return load(path, os.LookupEnv)
+}
+
+func load(path string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, b := range bindings {
+		if value, ok := lookupEnv(b.env); ok && value != "" {
+			if err := set(b.field(cfg), value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s '%s': %v", b.env, value, err))
+			}
+		}
+	}
+
+	// An OpenAI embedding backend can share the chat completions key
+	if cfg.Embedding.Backend == embedding.BackendOpenAI && cfg.Embedding.APIKey == "" {
+		cfg.Embedding.APIKey = cfg.OpenAI.APIKey
+	}
+
+	if err := errors.Join(append(errs, cfg.validate()...)...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// readFile decodes a YAML or TOML file, chosen by its extension, into cfg.
+// Unknown keys are errors, a typo must not silently keep the default.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %v", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("error parsing %s: %v", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %v", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("error parsing %s: unknown keys %v", path, undecoded)
+		}
+	default:
+		return fmt.Errorf("config file %s: unsupported format '%s', expected .yaml, .yml or .toml", path, ext)
+	}
+	return nil
+}
+
+// validate returns every problem with the settings used by both binaries
+func (c *Config) validate() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	checkURL := func(key, value string) {
+		if value == "" {
+			return
+		}
+		u, err := url.Parse(value)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"%s '%s' is not an http(s) URL", key, value)
+	}
+
+	check(c.Qdrant.Collection != "", "qdrant.collection must not be empty")
+	checkURL("qdrant.address", c.Qdrant.Address)
+	check(c.Qdrant.Address != "", "qdrant.address must not be empty")
+	checkURL("openai.url", c.OpenAI.URL)
+	checkURL("embedding.address", c.Embedding.Address)
+	checkURL("search.rerank_address", c.Search.RerankAddress)
+
+	switch c.Embedding.Backend {
+	case embedding.BackendService, embedding.BackendOllama:
+	case embedding.BackendOpenAI:
+		check(c.Embedding.APIKey != "", "embedding.api_key is required for the %s backend", embedding.BackendOpenAI)
+	default:
+		errs = append(errs, fmt.Errorf("embedding.backend '%s' is unknown, expected %s, %s or %s",
+			c.Embedding.Backend, embedding.BackendService, embedding.BackendOpenAI, embedding.BackendOllama))
+	}
+	check(c.Embedding.CacheSize >= 0, "embedding.cache_size must not be negative")
+
+	check(c.OpenAI.Timeout > 0, "openai.timeout must be positive")
+	check(c.Qdrant.Timeout > 0, "qdrant.timeout must be positive")
+	check(c.Embedding.Timeout > 0, "embedding.timeout must be positive")
+	check(c.Search.RerankTimeout > 0, "search.rerank_timeout must be positive")
+
+	switch c.Search.Reranker {
+	case RerankerCrossEncoder, RerankerLexical, RerankerNone:
+	default:
+		errs = append(errs, fmt.Errorf("search.reranker '%s' is unknown, expected %s, %s or %s",
+			c.Search.Reranker, RerankerCrossEncoder, RerankerLexical, RerankerNone))
+	}
+	check(c.Search.Limit > 0, "search.limit must be positive")
+	check(c.Search.CandidateLimit >= c.Search.Limit, "search.candidate_limit must be at least search.limit")
+	check(c.Search.MMRLambda >= 0 && c.Search.MMRLambda <= 1, "search.mmr_lambda must be between 0 and 1")
+
+	check(c.Chunking.MaxSize > 0, "chunking.max_size must be positive")
+	check(c.Chunking.SoftLimit > 0, "chunking.soft_limit must be positive")
+	check(c.Chunking.HardLimit >= c.Chunking.SoftLimit, "chunking.hard_limit must be at least chunking.soft_limit")
+
+	check(c.Buffer.IdleTimeout >= 0, "buffer.idle_timeout must not be negative")
+	check(c.Buffer.MaxAge >= 0, "buffer.max_age must not be negative")
+	check(c.Outbox.MaxAttempts > 0, "outbox.max_attempts must be positive")
+
+	if _, err := c.Telegram.Location(); err != nil {
+		errs = append(errs, fmt.Errorf("telegram.timezone '%s': %v", c.Telegram.Timezone, err))
+	}
+	return errs
+}
+
+// ValidateBot checks the settings only the bot needs
+func (c *Config) ValidateBot() error {
+	var errs []error
+	if c.Telegram.Token == "" {
+		errs = append(errs, errors.New("telegram.token is required (TELEGRAM_BOT_TOKEN)"))
+	}
+	if c.OpenAI.APIKey == "" {
+		errs = append(errs, errors.New("openai.api_key is required (OPENAI_API_KEY)"))
+	}
+	return errors.Join(errs...)
+}
+
+// Redacted returns the effective configuration, one "key = value" line per
+// setting, with secrets replaced
+func (c *Config) Redacted() string {
+	var lines []string
+	for _, b := range bindings {
+		value := format(b.field(c))
+		if b.secret && value != "" {
+			value = "<redacted>"
+		}
+		lines = append(lines, fmt.Sprintf("%s = %s", b.key, value))
+	}
+	return strings.Join(lines, "\n")
+}
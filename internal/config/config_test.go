@@ -0,0 +1,135 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return path
+}
+
+func env(values map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := load("", env(nil))
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if cfg.Qdrant.Collection != "chat_history" || cfg.Search.Limit != 5 || cfg.Embedding.Backend != "service" {
+		t.Errorf("Unexpected defaults: %+v", cfg)
+	}
+	if err := cfg.ValidateBot(); err == nil || !strings.Contains(err.Error(), "TELEGRAM_BOT_TOKEN") {
+		t.Errorf("Expected the bot to need a token, got %v", err)
+	}
+}
+
+func TestLoad_YAMLWithEnvOverride(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+telegram:
+  token: from-file
+  groups: [-1001, -1002]
+  timezone: Europe/Berlin
+qdrant:
+  collection: team_history
+  timeout: 5s
+search:
+  limit: 8
+  mmr_lambda: 0.5
+buffer:
+  idle_timeout: 30m
+`)
+	cfg, err := load(path, env(map[string]string{
+		"TELEGRAM_BOT_TOKEN": "from-env",
+		"SEARCH_LIMIT":       "3",
+		"OPENAI_API_KEY":     "sk-secret",
+	}))
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if cfg.Telegram.Token != "from-env" || cfg.Search.Limit != 3 {
+		t.Errorf("Environment did not override the file: %+v", cfg)
+	}
+	if len(cfg.Telegram.Groups) != 2 || cfg.Qdrant.Collection != "team_history" || cfg.Qdrant.Timeout != 5*time.Second ||
+		cfg.Search.MMRLambda != 0.5 || cfg.Buffer.IdleTimeout != 30*time.Minute {
+		t.Errorf("File not applied: %+v", cfg)
+	}
+	if err := cfg.ValidateBot(); err != nil {
+		t.Errorf("ValidateBot failed: %v", err)
+	}
+
+	printed := cfg.Redacted()
+	if strings.Contains(printed, "from-env") || strings.Contains(printed, "sk-secret") {
+		t.Errorf("Secrets not redacted:\n%s", printed)
+	}
+	for _, line := range []string{"telegram.token = <redacted>", "telegram.groups = -1001,-1002", "qdrant.timeout = 5s", "embedding.api_key = "} {
+		if !strings.Contains(printed, line+"\n") {
+			t.Errorf("Missing %q in:\n%s", line, printed)
+		}
+	}
+}
+
+func TestLoad_TOML(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+[embedding]
+backend = "openai"
+model = "text-embedding-3-large"
+timeout = "2m"
+
+[openai]
+api_key = "sk-shared"
+`)
+	cfg, err := load(path, env(nil))
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if cfg.Embedding.Model != "text-embedding-3-large" || cfg.Embedding.Timeout != 2*time.Minute {
+		t.Errorf("File not applied: %+v", cfg.Embedding)
+	}
+	if cfg.Embedding.APIKey != "sk-shared" {
+		t.Errorf("Expected the OpenAI key to be shared with the embedding backend, got %q", cfg.Embedding.APIKey)
+	}
+}
+
+func TestLoad_UnknownKey(t *testing.T) {
+	for name, content := range map[string]string{
+		"config.yaml": "qdrant:\n  colection: typo\n",
+		"config.toml": "[qdrant]\ncolection = \"typo\"\n",
+	} {
+		if _, err := load(writeFile(t, name, content), env(nil)); err == nil || !strings.Contains(err.Error(), "colection") {
+			t.Errorf("%s: expected an error naming the unknown key, got %v", name, err)
+		}
+	}
+}
+
+func TestLoad_ReportsAllErrors(t *testing.T) {
+	_, err := load("", env(map[string]string{
+		"QDRANT_SERVICE_ADDRESS": "localhost:6333",
+		"MMR_LAMBDA":             "2",
+		"SEARCH_LIMIT":           "many",
+		"TG_GROUP_LIST":          "-1001,general",
+		"RERANKER":               "magic",
+		"CHUNK_SOFT_LIMIT":       "5000",
+		"CHAT_TIMEZONE":          "Mars/Olympus",
+	}))
+	if err == nil {
+		t.Fatal("Expected validation errors")
+	}
+	for _, want := range []string{"qdrant.address", "mmr_lambda", "SEARCH_LIMIT", "general", "search.reranker", "chunking.hard_limit", "Mars/Olympus"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Missing %q in %v", want, err)
+		}
+	}
+}
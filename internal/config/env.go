@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// binding ties a setting to its config file key and environment variable
+type binding struct {
+	key    string // Dotted key in the config file
+	env    string // Environment variable overriding the file
+	secret bool   // Redacted when printed
+	field  func(*Config) interface{}
+}
+
+// bindings lists every setting in the order they are printed
+var bindings = []binding{
+	{key: "telegram.token", env: "TELEGRAM_BOT_TOKEN", secret: true, field: func(c *Config) interface{} { return &c.Telegram.Token }},
+	{key: "telegram.groups", env: "TG_GROUP_LIST", field: func(c *Config) interface{} { return &c.Telegram.Groups }},
+	{key: "telegram.timezone", env: "CHAT_TIMEZONE", field: func(c *Config) interface{} { return &c.Telegram.Timezone }},
+
+	{key: "openai.api_key", env: "OPENAI_API_KEY", secret: true, field: func(c *Config) interface{} { return &c.OpenAI.APIKey }},
+	{key: "openai.url", env: "OPENAI_API_URL", field: func(c *Config) interface{} { return &c.OpenAI.URL }},
+	{key: "openai.model", env: "OPENAI_MODEL", field: func(c *Config) interface{} { return &c.OpenAI.Model }},
+	{key: "openai.timeout", env: "OPENAI_TIMEOUT", field: func(c *Config) interface{} { return &c.OpenAI.Timeout }},
+
+	{key: "qdrant.address", env: "QDRANT_SERVICE_ADDRESS", field: func(c *Config) interface{} { return &c.Qdrant.Address }},
+	{key: "qdrant.collection", env: "QDRANT_COLLECTION", field: func(c *Config) interface{} { return &c.Qdrant.Collection }},
+	{key: "qdrant.timeout", env: "QDRANT_TIMEOUT", field: func(c *Config) interface{} { return &c.Qdrant.Timeout }},
+
+	{key: "embedding.backend", env: "EMBEDDING_BACKEND", field: func(c *Config) interface{} { return &c.Embedding.Backend }},
+	{key: "embedding.address", env: "EMBEDDING_SERVICE_ADDRESS", field: func(c *Config) interface{} { return &c.Embedding.Address }},
+	{key: "embedding.model", env: "EMBEDDING_MODEL", field: func(c *Config) interface{} { return &c.Embedding.Model }},
+	{key: "embedding.api_key", env: "EMBEDDING_API_KEY", secret: true, field: func(c *Config) interface{} { return &c.Embedding.APIKey }},
+	{key: "embedding.timeout", env: "EMBEDDING_TIMEOUT", field: func(c *Config) interface{} { return &c.Embedding.Timeout }},
+	{key: "embedding.cache_size", env: "EMBEDDING_CACHE_SIZE", field: func(c *Config) interface{} { return &c.Embedding.CacheSize }},
+	{key: "embedding.cache_path", env: "EMBEDDING_CACHE_PATH", field: func(c *Config) interface{} { return &c.Embedding.CachePath }},
+
+	{key: "search.limit", env: "SEARCH_LIMIT", field: func(c *Config) interface{} { return &c.Search.Limit }},
+	{key: "search.candidate_limit", env: "SEARCH_CANDIDATE_LIMIT", field: func(c *Config) interface{} { return &c.Search.CandidateLimit }},
+	{key: "search.reranker", env: "RERANKER", field: func(c *Config) interface{} { return &c.Search.Reranker }},
+	{key: "search.rerank_address", env: "RERANK_SERVICE_ADDRESS", field: func(c *Config) interface{} { return &c.Search.RerankAddress }},
+	{key: "search.rerank_timeout", env: "RERANK_TIMEOUT", field: func(c *Config) interface{} { return &c.Search.RerankTimeout }},
+	{key: "search.mmr_lambda", env: "MMR_LAMBDA", field: func(c *Config) interface{} { return &c.Search.MMRLambda }},
+
+	{key: "chunking.max_size", env: "CHUNK_MAX_SIZE", field: func(c *Config) interface{} { return &c.Chunking.MaxSize }},
+	{key: "chunking.soft_limit", env: "CHUNK_SOFT_LIMIT", field: func(c *Config) interface{} { return &c.Chunking.SoftLimit }},
+	{key: "chunking.hard_limit", env: "CHUNK_HARD_LIMIT", field: func(c *Config) interface{} { return &c.Chunking.HardLimit }},
+
+	{key: "buffer.idle_timeout", env: "BUFFER_IDLE_TIMEOUT", field: func(c *Config) interface{} { return &c.Buffer.IdleTimeout }},
+	{key: "buffer.max_age", env: "BUFFER_MAX_AGE", field: func(c *Config) interface{} { return &c.Buffer.MaxAge }},
+	{key: "buffer.wal_path", env: "BUFFER_WAL_PATH", field: func(c *Config) interface{} { return &c.Buffer.WALPath }},
+
+	{key: "outbox.dir", env: "OUTBOX_DIR", field: func(c *Config) interface{} { return &c.Outbox.Dir }},
+	{key: "outbox.max_attempts", env: "OUTBOX_MAX_ATTEMPTS", field: func(c *Config) interface{} { return &c.Outbox.MaxAttempts }},
+
+	{key: "metrics.address", env: "METRICS_ADDRESS", field: func(c *Config) interface{} { return &c.Metrics.Address }},
+}
+
+// set parses an environment value into the field it points to
+func set(field interface{}, value string) error {
+	switch field := field.(type) {
+	case *string:
+		*field = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("not a number")
+		}
+		*field = n
+	case *float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("not a number")
+		}
+		*field = f
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("not a duration like 30s or 2h")
+		}
+		*field = d
+	case *[]int64:
+		// A comma-separated list, e.g. TG_GROUP_LIST=-1001234,-1005678
+		var ids []int64
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(item, 10, 64)
+			if err != nil {
+				return fmt.Errorf("'%s' is not a chat ID", item)
+			}
+			ids = append(ids, id)
+		}
+		*field = ids
+	default:
+		return fmt.Errorf("unsupported setting type %T", field)
+	}
+	return nil
+}
+
+// format prints the field a binding points to
+func format(field interface{}) string {
+	switch field := field.(type) {
+	case *string:
+		return *field
+	case *[]int64:
+		ids := make([]string, len(*field))
+		for i, id := range *field {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		return strings.Join(ids, ",")
+	case *int:
+		return strconv.Itoa(*field)
+	case *float64:
+		return strconv.FormatFloat(*field, 'g', -1, 64)
+	case *time.Duration:
+		return field.String()
+	default:
+		return fmt.Sprint(field)
+	}
+}
@@ -0,0 +1,269 @@
+package embedding
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultCacheSize is the number of embeddings kept in memory
+const DefaultCacheSize = 10000
+
+// diskOpenTimeout bounds the wait for the disk tier's file lock, which is
+// held by another process sharing the same file
+const diskOpenTimeout = time.Second
+
+// ErrCacheLocked is returned by NewCache when another process holds the
+// disk tier's file. bbolt locks the file exclusively, so processes sharing
+// a path take turns; callers fall back to the memory tier meanwhile.
+var ErrCacheLocked = errors.New("embedding cache file is in use by another process")
+
+// CacheConfig configures a Cache
+type CacheConfig struct {
+	Size int    // Embeddings kept in memory, 0 disables the memory tier
+	Path string // bbolt file of the disk tier, empty disables it
+}
+
+// CacheStats counts lookups of a Cache. Hits include DiskHits.
+type CacheStats struct {
+	Hits     int64
+	DiskHits int64
+	Misses   int64
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("%d hits (%d from disk), %d misses", s.Hits, s.DiskHits, s.Misses)
+}
+
+// Cache is an Embedder that remembers the embeddings of another one, keyed
+// by model ID and the SHA-256 of the text. Recently used embeddings are
+// kept in memory; with a disk tier they also survive restarts and are
+// shared with other processes using the same file one after another.
+type Cache struct {
+	embedder Embedder
+	memory   *lru
+	disk     *bolt.DB
+
+	hits     atomic.Int64
+	diskHits atomic.Int64
+	misses   atomic.Int64
+}
+
+// NewCache puts a cache in front of embedder. Close releases the disk tier.
+func NewCache(embedder Embedder, cfg CacheConfig) (*Cache, error) {
+	c := &Cache{embedder: embedder}
+	if cfg.Size > 0 {
+		c.memory = newLRU(cfg.Size)
+	}
+	if cfg.Path != "" {
+		db, err := bolt.Open(cfg.Path, 0o600, &bolt.Options{Timeout: diskOpenTimeout})
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, fmt.Errorf("error opening embedding cache '%s': %w", cfg.Path, ErrCacheLocked)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error opening embedding cache '%s': %w", cfg.Path, err)
+		}
+		c.disk = db
+	}
+	return c, nil
+}
+
+// Dimension implements Embedder
+func (c *Cache) Dimension() int {
+	return c.embedder.Dimension()
+}
+
+// ModelID implements Embedder
+func (c *Cache) ModelID() string {
+	return c.embedder.ModelID()
+}
+
+// Stats returns the lookup counters since the cache was created
+func (c *Cache) Stats() CacheStats {
+	return CacheStats{Hits: c.hits.Load(), DiskHits: c.diskHits.Load(), Misses: c.misses.Load()}
+}
+
+// Close closes the disk tier
+func (c *Cache) Close() error {
+	if c.disk == nil {
+		return nil
+	}
+	return c.disk.Close()
+}
+
+// Embed implements Embedder. Only texts found in neither tier are sent to
+// the underlying embedder, each distinct text once.
+func (c *Cache) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	hashes := make([][sha256.Size]byte, len(texts))
+	missing := make(map[[sha256.Size]byte][]int)
+	var missingTexts []string
+
+	for i, text := range texts {
+		hashes[i] = sha256.Sum256([]byte(text))
+		if embedding, ok := c.lookup(hashes[i]); ok {
+			embeddings[i] = embedding
+			continue
+		}
+		if _, ok := missing[hashes[i]]; !ok {
+			missingTexts = append(missingTexts, text)
+		}
+		missing[hashes[i]] = append(missing[hashes[i]], i)
+	}
+	if len(missingTexts) == 0 {
+		return embeddings, nil
+	}
+
+	c.misses.Add(int64(len(missingTexts)))
+	computed, err := c.embedder.Embed(ctx, missingTexts)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCount(computed, missingTexts); err != nil {
+		return nil, err
+	}
+
+	stored := make(map[[sha256.Size]byte][]float32, len(computed))
+	for i, text := range missingTexts {
+		hash := sha256.Sum256([]byte(text))
+		stored[hash] = computed[i]
+		for _, index := range missing[hash] {
+			embeddings[index] = computed[i]
+		}
+	}
+	c.store(stored)
+	return embeddings, nil
+}
+
+// lookup checks the memory tier, then the disk tier, and copies disk hits
+// into memory
+func (c *Cache) lookup(hash [sha256.Size]byte) ([]float32, bool) {
+	key := c.ModelID() + string(hash[:])
+	if c.memory != nil {
+		if embedding, ok := c.memory.get(key); ok {
+			c.hits.Add(1)
+			return embedding, true
+		}
+	}
+	if c.disk == nil {
+		return nil, false
+	}
+
+	var embedding []float32
+	c.disk.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(c.ModelID()))
+		if bucket == nil {
+			return nil
+		}
+		if value := bucket.Get(hash[:]); value != nil {
+			embedding = decodeVector(value)
+		}
+		return nil
+	})
+	if embedding == nil {
+		return nil, false
+	}
+
+	c.hits.Add(1)
+	c.diskHits.Add(1)
+	if c.memory != nil {
+		c.memory.put(key, embedding)
+	}
+	return embedding, true
+}
+
+// store adds freshly computed embeddings to both tiers. A failing disk
+// write only costs a future recomputation, so it is not reported.
+func (c *Cache) store(embeddings map[[sha256.Size]byte][]float32) {
+	if c.memory != nil {
+		for hash, embedding := range embeddings {
+			c.memory.put(c.ModelID()+string(hash[:]), embedding)
+		}
+	}
+	if c.disk == nil {
+		return
+	}
+	c.disk.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(c.ModelID()))
+		if err != nil {
+			return err
+		}
+		for hash, embedding := range embeddings {
+			if err := bucket.Put(hash[:], encodeVector(embedding)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func encodeVector(vector []float32) []byte {
+	encoded := make([]byte, 4*len(vector))
+	for i, value := range vector {
+		binary.LittleEndian.PutUint32(encoded[4*i:], math.Float32bits(value))
+	}
+	return encoded
+}
+
+func decodeVector(encoded []byte) []float32 {
+	vector := make([]float32, len(encoded)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(encoded[4*i:]))
+	}
+	return vector
+}
+
+// lru is a fixed-size map that evicts the least recently used entry
+type lru struct {
+	mutex   sync.Mutex
+	size    int
+	order   *list.List // Front is the most recently used
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value []float32
+}
+
+func newLRU(size int) *lru {
+	return &lru{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (l *lru) get(key string) ([]float32, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	element, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(element)
+	return element.Value.(*lruEntry).value, true
+}
+
+func (l *lru) put(key string, value []float32) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if element, ok := l.entries[key]; ok {
+		element.Value.(*lruEntry).value = value
+		l.order.MoveToFront(element)
+		return
+	}
+	l.entries[key] = l.order.PushFront(&lruEntry{key: key, value: value})
+	if l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*lruEntry).key)
+	}
+}
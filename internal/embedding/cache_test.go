@@ -0,0 +1,121 @@
+package embedding
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// countingEmbedder embeds a text as its length and records what it was asked
+type countingEmbedder struct {
+	modelID string
+	calls   [][]string
+}
+
+func (c *countingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	c.calls = append(c.calls, texts)
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embeddings[i] = []float32{float32(len(text)), 1}
+	}
+	return embeddings, nil
+}
+
+func (c *countingEmbedder) Dimension() int  { return 2 }
+func (c *countingEmbedder) ModelID() string { return c.modelID }
+
+func TestCache_MemoryTier(t *testing.T) {
+	backend := &countingEmbedder{modelID: "test"}
+	cache, err := NewCache(backend, CacheConfig{Size: 10})
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+
+	embeddings, err := cache.Embed(context.Background(), []string{"a", "bb", "a"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if len(embeddings) != 3 || embeddings[1][0] != 2 || embeddings[2][0] != 1 {
+		t.Errorf("Unexpected embeddings: %v", embeddings)
+	}
+	if len(backend.calls) != 1 || len(backend.calls[0]) != 2 {
+		t.Errorf("Expected one call with the two distinct texts, got %v", backend.calls)
+	}
+
+	if _, err := cache.Embed(context.Background(), []string{"bb", "ccc"}); err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if len(backend.calls) != 2 || len(backend.calls[1]) != 1 || backend.calls[1][0] != "ccc" {
+		t.Errorf("Expected only the new text to be embedded, got %v", backend.calls)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.DiskHits != 0 {
+		t.Errorf("Unexpected stats: %s", stats)
+	}
+}
+
+func TestCache_Evicts(t *testing.T) {
+	backend := &countingEmbedder{modelID: "test"}
+	cache, _ := NewCache(backend, CacheConfig{Size: 2})
+
+	cache.Embed(context.Background(), []string{"a"})
+	cache.Embed(context.Background(), []string{"bb"})
+	cache.Embed(context.Background(), []string{"a"}) // "bb" is now the oldest
+	cache.Embed(context.Background(), []string{"ccc"})
+	cache.Embed(context.Background(), []string{"a", "bb"})
+
+	if stats := cache.Stats(); stats.Hits != 2 || stats.Misses != 4 {
+		t.Errorf("Unexpected stats: %s", stats)
+	}
+}
+
+func TestCache_DiskTier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "embeddings.db")
+
+	first, err := NewCache(&countingEmbedder{modelID: "test"}, CacheConfig{Size: 10, Path: path})
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+	first.Embed(context.Background(), []string{"persisted"})
+
+	if _, err := NewCache(&countingEmbedder{modelID: "test"}, CacheConfig{Path: path}); err == nil {
+		t.Error("Expected the open cache file to be locked")
+	}
+	first.Close()
+
+	backend := &countingEmbedder{modelID: "test"}
+	second, err := NewCache(backend, CacheConfig{Size: 10, Path: path})
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+	defer second.Close()
+
+	embeddings, err := second.Embed(context.Background(), []string{"persisted"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if len(backend.calls) != 0 || embeddings[0][0] != float32(len("persisted")) {
+		t.Errorf("Expected a disk hit, got calls %v and embeddings %v", backend.calls, embeddings)
+	}
+	if stats := second.Stats(); stats.Hits != 1 || stats.DiskHits != 1 {
+		t.Errorf("Unexpected stats: %s", stats)
+	}
+}
+
+func TestCache_KeyedByModel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "embeddings.db")
+
+	first, _ := NewCache(&countingEmbedder{modelID: "one"}, CacheConfig{Path: path})
+	first.Embed(context.Background(), []string{"text"})
+	first.Close()
+
+	backend := &countingEmbedder{modelID: "two"}
+	second, _ := NewCache(backend, CacheConfig{Path: path})
+	defer second.Close()
+	second.Embed(context.Background(), []string{"text"})
+
+	if len(backend.calls) != 1 {
+		t.Error("Expected another model's embedding not to be reused")
+	}
+}
@@ -0,0 +1,143 @@
+// Package embedding turns texts into dense vectors for the bot and the
+// backup importer. The backend is chosen by configuration: the project's
+// embedding service, an OpenAI-compatible API or Ollama.
+package embedding
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultTimeout bounds every request of an embedder created without its
+// own http.Client
+const DefaultTimeout = 2 * time.Minute
+
+// probeText is embedded to measure the dimension of a model
+const probeText = "dimension probe"
+
+// Backends selectable with Config.Backend
+const (
+	BackendService = "service" // The embedding_service of this repository
+	BackendOpenAI  = "openai"  // Any OpenAI-compatible /v1/embeddings endpoint
+	BackendOllama  = "ollama"  // Ollama's /api/embed
+)
+
+// Default endpoints and models per backend
+const (
+	DefaultServiceURL  = "http://localhost:8000/embeddings"
+	DefaultOpenAIURL   = "https://api.openai.com/v1/embeddings"
+	DefaultOpenAIModel = "text-embedding-3-small"
+	DefaultOllamaURL   = "http://localhost:11434/api/embed"
+	DefaultOllamaModel = "nomic-embed-text"
+)
+
+// Embedder turns texts into dense vectors of a fixed dimension
+type Embedder interface {
+	// Embed returns one embedding per text, in order
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// Dimension is the length of every embedding
+	Dimension() int
+	// ModelID identifies backend and model, e.g. "ollama:nomic-embed-text".
+	// Embeddings of different model IDs are not comparable.
+	ModelID() string
+}
+
+// Config selects and configures an embedding backend. Empty fields take
+// the backend's defaults.
+type Config struct {
+	Backend    string
+	URL        string
+	Model      string
+	APIKey     string       // Only used by the OpenAI backend
+	HTTPClient *http.Client // Defaults to a client with DefaultTimeout
+}
+
+// backend is the part every implementation provides, New adds the probed
+// dimension and model ID
+type backend interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	probe(ctx context.Context) (modelID string, dimension int, err error)
+}
+
+type embedder struct {
+	backend
+	modelID   string
+	dimension int
+}
+
+func (e *embedder) Dimension() int {
+	return e.dimension
+}
+
+func (e *embedder) ModelID() string {
+	return e.modelID
+}
+
+// New creates the configured backend and probes it for its model and
+// dimension, so a misconfigured backend fails at startup
+func New(ctx context.Context, cfg Config) (Embedder, error) {
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+
+	var b backend
+	switch cfg.Backend {
+	case "", BackendService:
+		b = &Service{url: orDefault(cfg.URL, DefaultServiceURL), http: client}
+	case BackendOpenAI:
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("the %s embedding backend needs an API key", BackendOpenAI)
+		}
+		b = &OpenAI{
+			url:    orDefault(cfg.URL, DefaultOpenAIURL),
+			model:  orDefault(cfg.Model, DefaultOpenAIModel),
+			apiKey: cfg.APIKey,
+			http:   client,
+		}
+	case BackendOllama:
+		b = &Ollama{
+			url:   orDefault(cfg.URL, DefaultOllamaURL),
+			model: orDefault(cfg.Model, DefaultOllamaModel),
+			http:  client,
+		}
+	default:
+		return nil, fmt.Errorf("unknown embedding backend '%s', expected %s, %s or %s",
+			cfg.Backend, BackendService, BackendOpenAI, BackendOllama)
+	}
+
+	modelID, dimension, err := b.probe(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error probing embedding backend: %w", err)
+	}
+	return &embedder{backend: b, modelID: modelID, dimension: dimension}, nil
+}
+
+// probeDimension embeds probeText and returns the length of the result
+func probeDimension(ctx context.Context, b backend) (int, error) {
+	embeddings, err := b.Embed(ctx, []string{probeText})
+	if err != nil {
+		return 0, err
+	}
+	if len(embeddings[0]) == 0 {
+		return 0, fmt.Errorf("backend returned an empty embedding")
+	}
+	return len(embeddings[0]), nil
+}
+
+// checkCount makes sure a backend returned one embedding per text
+func checkCount(embeddings [][]float32, texts []string) error {
+	if len(embeddings) != len(texts) {
+		return fmt.Errorf("expected %d embeddings, got %d", len(texts), len(embeddings))
+	}
+	return nil
+}
+
+func orDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
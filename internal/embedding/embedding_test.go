@@ -0,0 +1,85 @@
+package embedding
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNew_OpenAI(t *testing.T) {
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		var req openAIRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "text-embedding-3-small" {
+			t.Errorf("Unexpected model %s", req.Model)
+		}
+
+		// Entries in reverse order, they must be matched by index
+		type entry struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		}
+		var data []entry
+		for i := len(req.Input) - 1; i >= 0; i-- {
+			data = append(data, entry{Index: i, Embedding: []float32{float32(i), 0}})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	defer server.Close()
+
+	embedder, err := New(context.Background(), Config{Backend: BackendOpenAI, URL: server.URL, APIKey: "secret"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if embedder.ModelID() != "openai:text-embedding-3-small" || embedder.Dimension() != 2 {
+		t.Errorf("Unexpected model %s with dimension %d", embedder.ModelID(), embedder.Dimension())
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Authorization = %q", auth)
+	}
+
+	embeddings, err := embedder.Embed(context.Background(), []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if embeddings[0][0] != 0 || embeddings[2][0] != 2 {
+		t.Errorf("Embeddings out of order: %v", embeddings)
+	}
+}
+
+func TestNew_OpenAIRequiresKey(t *testing.T) {
+	if _, err := New(context.Background(), Config{Backend: BackendOpenAI}); err == nil {
+		t.Error("Expected an error without an API key")
+	}
+}
+
+func TestNew_Ollama(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		embeddings := make([][]float32, len(req.Input))
+		for i := range embeddings {
+			embeddings[i] = []float32{1, 2, 3, 4}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"model": req.Model, "embeddings": embeddings})
+	}))
+	defer server.Close()
+
+	embedder, err := New(context.Background(), Config{Backend: BackendOllama, URL: server.URL, Model: "bge-m3"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if embedder.ModelID() != "ollama:bge-m3" || embedder.Dimension() != 4 {
+		t.Errorf("Unexpected model %s with dimension %d", embedder.ModelID(), embedder.Dimension())
+	}
+}
+
+func TestNew_UnknownBackend(t *testing.T) {
+	if _, err := New(context.Background(), Config{Backend: "word2vec"}); err == nil {
+		t.Error("Expected an error for an unknown backend")
+	}
+}
@@ -0,0 +1,46 @@
+package embedding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Ollama calls Ollama's /api/embed endpoint
+type Ollama struct {
+	url   string
+	model string
+	http  *http.Client
+}
+
+type ollamaRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type ollamaResponse struct {
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// Embed implements Embedder
+func (o *Ollama) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := post(ctx, o.http, o.url, "", ollamaRequest{Model: o.model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+
+	var response ollamaResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("error unmarshaling embeddings response: %v", err)
+	}
+	return response.Embeddings, checkCount(response.Embeddings, texts)
+}
+
+func (o *Ollama) probe(ctx context.Context) (string, int, error) {
+	dimension, err := probeDimension(ctx, o)
+	if err != nil {
+		return "", 0, err
+	}
+	return BackendOllama + ":" + o.model, dimension, nil
+}
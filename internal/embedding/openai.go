@@ -0,0 +1,59 @@
+package embedding
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// OpenAI calls an OpenAI-compatible /v1/embeddings endpoint
+type OpenAI struct {
+	url    string
+	model  string
+	apiKey string
+	http   *http.Client
+}
+
+type openAIRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type openAIResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed implements Embedder
+func (o *OpenAI) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := post(ctx, o.http, o.url, o.apiKey, openAIRequest{Model: o.model, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+
+	var response openAIResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("error unmarshaling embeddings response: %v", err)
+	}
+
+	// Entries carry their input index, the order is not guaranteed
+	embeddings := make([][]float32, len(response.Data))
+	for _, entry := range response.Data {
+		if entry.Index < 0 || entry.Index >= len(embeddings) {
+			return nil, fmt.Errorf("embedding index %d out of range", entry.Index)
+		}
+		embeddings[entry.Index] = entry.Embedding
+	}
+	return embeddings, checkCount(embeddings, texts)
+}
+
+func (o *OpenAI) probe(ctx context.Context) (string, int, error) {
+	dimension, err := probeDimension(ctx, o)
+	if err != nil {
+		return "", 0, err
+	}
+	return BackendOpenAI + ":" + o.model, dimension, nil
+}
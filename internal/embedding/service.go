@@ -0,0 +1,193 @@
+package embedding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Service calls this repository's embedding service. It speaks the
+// structured /v2/embeddings protocol and falls back to the legacy
+// /embeddings format for services that predate it.
+type Service struct {
+	url  string
+	http *http.Client
+	v2   bool // Set by probe when the service answers /v2/embeddings
+}
+
+// Info describes the model behind the embedding service
+type Info struct {
+	Model     string `json:"model"`
+	Dimension int    `json:"dimension"`
+}
+
+type textList struct {
+	Texts []string `json:"texts"`
+}
+
+// v2Response is the body of a successful /v2/embeddings request
+type v2Response struct {
+	Model      string      `json:"model"`
+	Dimension  int         `json:"dimension"`
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// StatusError is returned for a non-200 response of an embedding endpoint
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("error response from %s (status %d): %s", e.URL, e.StatusCode, e.Body)
+}
+
+// Embed implements Embedder
+func (s *Service) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if s.v2 {
+		response, err := s.embedV2(ctx, texts)
+		if err != nil {
+			return nil, err
+		}
+		return response.Embeddings, checkCount(response.Embeddings, texts)
+	}
+	return s.embedLegacy(ctx, texts)
+}
+
+func (s *Service) embedV2(ctx context.Context, texts []string) (v2Response, error) {
+	body, err := post(ctx, s.http, s.v2URL(), "", textList{Texts: texts})
+	if err != nil {
+		return v2Response{}, err
+	}
+
+	var response v2Response
+	if err := json.Unmarshal(body, &response); err != nil {
+		return v2Response{}, fmt.Errorf("error unmarshaling embeddings response: %v", err)
+	}
+	for _, embedding := range response.Embeddings {
+		if len(embedding) != response.Dimension {
+			return v2Response{}, fmt.Errorf("embedding of length %d, service reported dimension %d", len(embedding), response.Dimension)
+		}
+	}
+	return response, nil
+}
+
+// embedLegacy reads the /embeddings format: HTTP 200 with a string
+// containing a JSON array of arrays, or with {"error": ...} on failure
+func (s *Service) embedLegacy(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := post(ctx, s.http, s.url, "", textList{Texts: texts})
+	if err != nil {
+		return nil, err
+	}
+
+	var embeddingString string
+	if err := json.Unmarshal(body, &embeddingString); err != nil {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &failure) == nil && failure.Error != "" {
+			return nil, fmt.Errorf("embedding service error: %s", failure.Error)
+		}
+		return nil, fmt.Errorf("error unmarshaling embedding string: %v, body: %s", err, string(body))
+	}
+
+	var embeddings [][]float32
+	if err := json.Unmarshal([]byte(embeddingString), &embeddings); err != nil {
+		return nil, fmt.Errorf("error unmarshaling embedding list: %v", err)
+	}
+	return embeddings, checkCount(embeddings, texts)
+}
+
+// probe negotiates the protocol: a service answering /v2/embeddings
+// reports its model and dimension along with the probe embedding. Older
+// services are asked via /info and, without one, the probe text is embedded
+// and measured; the model is then identified by the endpoint URL.
+func (s *Service) probe(ctx context.Context) (string, int, error) {
+	response, err := s.embedV2(ctx, []string{probeText})
+	if err == nil {
+		s.v2 = true
+		return BackendService + ":" + response.Model, response.Dimension, nil
+	}
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || (statusErr.StatusCode != http.StatusNotFound && statusErr.StatusCode != http.StatusMethodNotAllowed) {
+		return "", 0, err
+	}
+
+	if info, err := s.info(ctx); err == nil && info.Dimension > 0 {
+		return BackendService + ":" + info.Model, info.Dimension, nil
+	}
+
+	dimension, err := probeDimension(ctx, s)
+	if err != nil {
+		return "", 0, err
+	}
+	return BackendService + ":" + s.url, dimension, nil
+}
+
+// v2URL returns the /v2/embeddings endpoint next to the configured one
+func (s *Service) v2URL() string {
+	return strings.TrimSuffix(s.url, "/embeddings") + "/v2/embeddings"
+}
+
+func (s *Service) info(ctx context.Context) (Info, error) {
+	infoURL := strings.TrimSuffix(s.url, "/embeddings") + "/info"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, infoURL, nil)
+	if err != nil {
+		return Info{}, err
+	}
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return Info{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Info{}, fmt.Errorf("error response from embedding service info (status %d)", resp.StatusCode)
+	}
+	var info Info
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return Info{}, err
+	}
+	return info, nil
+}
+
+// post sends a JSON request and returns the body of a 200 response, other
+// responses become a *StatusError. A non-empty apiKey is sent as a bearer
+// token.
+func post(ctx context.Context, client *http.Client, url, apiKey string, request interface{}) ([]byte, error) {
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{URL: url, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return body, nil
+}
@@ -0,0 +1,139 @@
+package embedding
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// legacyHandler answers /embeddings like the embedding service does, with
+// a JSON string containing the list of embeddings
+func legacyHandler(dimension int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req textList
+		json.NewDecoder(r.Body).Decode(&req)
+		list := make([][]float32, len(req.Texts))
+		for i := range list {
+			list[i] = make([]float32, dimension)
+		}
+		encoded, _ := json.Marshal(list)
+		json.NewEncoder(w).Encode(string(encoded))
+	}
+}
+
+func TestService_Embed(t *testing.T) {
+	server := httptest.NewServer(legacyHandler(3))
+	defer server.Close()
+
+	service := &Service{url: server.URL + "/embeddings", http: http.DefaultClient}
+	embeddings, err := service.Embed(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if len(embeddings) != 2 || len(embeddings[1]) != 3 {
+		t.Errorf("Unexpected embeddings: %v", embeddings)
+	}
+}
+
+func TestService_EmbedErrorBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"error": "Model not initialized. Check server logs."}`))
+	}))
+	defer server.Close()
+
+	service := &Service{url: server.URL, http: http.DefaultClient}
+	if _, err := service.Embed(context.Background(), []string{"a"}); err == nil {
+		t.Error("Expected an error for an error body")
+	}
+}
+
+func TestService_ProbeInfo(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"model": "distiluse-base-multilingual-cased-v1", "dimension": 512}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	embedder, err := New(context.Background(), Config{URL: server.URL + "/embeddings"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if embedder.ModelID() != "service:distiluse-base-multilingual-cased-v1" || embedder.Dimension() != 512 {
+		t.Errorf("Unexpected model %s with dimension %d", embedder.ModelID(), embedder.Dimension())
+	}
+}
+
+func TestService_ProbeFallsBackToEmbedding(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/embeddings", legacyHandler(384))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	embedder, err := New(context.Background(), Config{Backend: BackendService, URL: server.URL + "/embeddings"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if embedder.Dimension() != 384 {
+		t.Errorf("Dimension = %d, want 384", embedder.Dimension())
+	}
+}
+
+// v2Handler answers /v2/embeddings with the structured response
+func v2Handler(dimension int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req textList
+		json.NewDecoder(r.Body).Decode(&req)
+		response := v2Response{Model: "test-model", Dimension: dimension, Embeddings: make([][]float32, len(req.Texts))}
+		for i := range response.Embeddings {
+			response.Embeddings[i] = make([]float32, dimension)
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+func TestService_NegotiatesV2(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/v2/embeddings", v2Handler(4))
+	mux.HandleFunc("/embeddings", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Legacy endpoint called although v2 is available")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	embedder, err := New(context.Background(), Config{URL: server.URL + "/embeddings"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if embedder.ModelID() != "service:test-model" || embedder.Dimension() != 4 {
+		t.Errorf("Unexpected model %s with dimension %d", embedder.ModelID(), embedder.Dimension())
+	}
+
+	embeddings, err := embedder.Embed(context.Background(), []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if len(embeddings) != 3 || len(embeddings[2]) != 4 {
+		t.Errorf("Unexpected embeddings: %v", embeddings)
+	}
+}
+
+func TestService_V2ErrorIsNotLegacy(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/embeddings", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error": "Model not initialized. Check server logs."}`))
+	})
+	mux.Handle("/embeddings", legacyHandler(3))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	_, err := New(context.Background(), Config{URL: server.URL + "/embeddings"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected the 503 of /v2/embeddings, got %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package outbox
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockFile opens path as the lock file. Without flock there is no lock,
+// the directory must not be shared between processes.
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+	return file, nil
+}
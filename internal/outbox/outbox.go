@@ -0,0 +1,411 @@
+// Package outbox keeps chunks that could not be stored in Qdrant on disk and
+// retries them later. An entry that keeps failing is moved to a dead-letter
+// file, where it waits to be inspected and replayed by hand.
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+	"github.com/korjavin/ragtgbot/internal/schema"
+	"github.com/korjavin/ragtgbot/internal/sparse"
+)
+
+const (
+	// DefaultMaxAttempts is the number of failed attempts, including the
+	// first one, after which an entry becomes a dead letter
+	DefaultMaxAttempts = 10
+
+	// MinRetryDelay is the wait after the first failure, doubled after
+	// every further one up to MaxRetryDelay
+	MinRetryDelay = time.Minute
+	MaxRetryDelay = time.Hour
+
+	// File names inside the outbox directory
+	pendingFile    = "outbox.jsonl"
+	deadLetterFile = "dead-letter.jsonl"
+	lockFileName   = "outbox.lock"
+
+	// importSubdir holds the outbox of backup imports inside the bot's, so
+	// that an import running next to the bot has a directory of its own
+	importSubdir = "import"
+)
+
+// ErrLocked is returned by Open and Replay when another process is using
+// the outbox directory
+var ErrLocked = errors.New("outbox directory is in use by another process")
+
+// Entry is a chunk waiting to be stored: the text to embed and the payload
+// of its point
+type Entry struct {
+	ID          qdrant.PointID         `json:"id"`
+	Text        string                 `json:"text"`
+	Payload     map[string]interface{} `json:"payload"`
+	Attempts    int                    `json:"attempts"`
+	LastError   string                 `json:"last_error"`
+	Added       time.Time              `json:"added"`
+	NextAttempt time.Time              `json:"next_attempt"`
+}
+
+// Point returns the entry as a Qdrant point with the given dense vector
+// and, if keywordSearch is set, the sparse keyword vector of its text
+func (e Entry) Point(embedding []float32, keywordSearch bool) qdrant.Point {
+	vectors := qdrant.NamedVectors{
+		schema.DenseVectorName: qdrant.DenseVector(embedding),
+	}
+	if keywordSearch {
+		vectors[schema.SparseVectorName] = qdrant.SparseVector(sparse.EncodeDocument(e.Text))
+	}
+	return qdrant.Point{ID: e.ID, Vector: vectors, Payload: e.Payload}
+}
+
+// FlushResult counts what happened to the entries of one Flush
+type FlushResult struct {
+	Stored   int // Written and removed from the outbox
+	Retrying int // Failed again, retried later
+	Dead     int // Failed for the last time, moved to the dead-letter file
+}
+
+// Outbox is the durable queue of a directory. Each process rewrites the
+// files from its own copy of the entries, so the directory is locked while
+// it is open.
+type Outbox struct {
+	dir         string
+	lock        *os.File
+	maxAttempts int
+	pending     []Entry
+	mutex       sync.Mutex
+	now         func() time.Time
+}
+
+// Open opens (or creates) the outbox in dir and loads its pending entries.
+// It fails with ErrLocked while another process has the directory open;
+// Close releases it.
+func Open(dir string, maxAttempts int) (*Outbox, error) {
+	lock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := readEntries(filepath.Join(dir, pendingFile))
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	if maxAttempts < 1 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	return &Outbox{dir: dir, lock: lock, maxAttempts: maxAttempts, pending: pending, now: time.Now}, nil
+}
+
+// Close releases the outbox directory
+func (o *Outbox) Close() error {
+	return o.lock.Close()
+}
+
+// lockDir creates the outbox directory if needed and locks it
+func lockDir(dir string) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating outbox %s: %v", dir, err)
+	}
+	lock, err := lockFile(filepath.Join(dir, lockFileName))
+	if err != nil {
+		return nil, fmt.Errorf("error opening outbox %s: %w", dir, err)
+	}
+	return lock, nil
+}
+
+// Len returns the number of pending entries
+func (o *Outbox) Len() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return len(o.pending)
+}
+
+// Entries returns the pending entries in the order they were added
+func (o *Outbox) Entries() []Entry {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	entries := make([]Entry, len(o.pending))
+	copy(entries, o.pending)
+	return entries
+}
+
+// Add records an entry whose first attempt failed with cause. An entry
+// with the same ID is replaced, keeping its attempt count.
+func (o *Outbox) Add(entry Entry, cause error) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	now := o.now()
+	entry.Attempts = 0
+	entry.Added = now
+	pending := make([]Entry, 0, len(o.pending)+1)
+	for _, existing := range o.pending {
+		if existing.ID == entry.ID {
+			entry.Attempts = existing.Attempts
+			entry.Added = existing.Added
+			continue
+		}
+		pending = append(pending, existing)
+	}
+	fail(&entry, cause, now)
+	if entry.Attempts >= o.maxAttempts {
+		return o.bury(pending, entry)
+	}
+	return o.save(append(pending, entry))
+}
+
+// Flush writes the entries whose retry is due and updates the outbox with
+// the outcome. The outbox is not locked while write runs.
+func (o *Outbox) Flush(ctx context.Context, write func(context.Context, Entry) error) (FlushResult, error) {
+	return o.flush(ctx, o.now(), write)
+}
+
+// FlushAll is Flush for every pending entry, due or not
+func (o *Outbox) FlushAll(ctx context.Context, write func(context.Context, Entry) error) (FlushResult, error) {
+	return o.flush(ctx, time.Time{}, write)
+}
+
+// flush writes the entries due at or before due, all of them for the zero
+// time
+func (o *Outbox) flush(ctx context.Context, due time.Time, write func(context.Context, Entry) error) (FlushResult, error) {
+	var result FlushResult
+	for _, entry := range o.Entries() {
+		if !due.IsZero() && entry.NextAttempt.After(due) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		err := write(ctx, entry)
+		dead, saveErr := o.settle(entry, err)
+		switch {
+		case saveErr != nil:
+			return result, saveErr
+		case err == nil:
+			result.Stored++
+		case dead:
+			result.Dead++
+		default:
+			result.Retrying++
+		}
+	}
+	return result, nil
+}
+
+// settle removes a written entry, or counts the failure and schedules the
+// next attempt, moving the entry to the dead letters after the last one
+func (o *Outbox) settle(entry Entry, cause error) (bool, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	pending := make([]Entry, 0, len(o.pending))
+	found := false
+	for _, existing := range o.pending {
+		if existing.ID == entry.ID {
+			entry, found = existing, true
+			continue
+		}
+		pending = append(pending, existing)
+	}
+	if !found {
+		return false, nil // Replaced or removed meanwhile
+	}
+
+	if cause == nil {
+		return false, o.save(pending)
+	}
+	fail(&entry, cause, o.now())
+	if entry.Attempts >= o.maxAttempts {
+		return true, o.bury(pending, entry)
+	}
+	return false, o.save(append(pending, entry))
+}
+
+// bury appends entry to the dead letters, then saves the remaining pending
+// entries; a crash in between leaves the entry in both files, never in none
+func (o *Outbox) bury(pending []Entry, entry Entry) error {
+	if err := appendEntry(filepath.Join(o.dir, deadLetterFile), entry); err != nil {
+		return err
+	}
+	return o.save(pending)
+}
+
+func (o *Outbox) save(pending []Entry) error {
+	if err := writeEntries(filepath.Join(o.dir, pendingFile), pending); err != nil {
+		return err
+	}
+	o.pending = pending
+	return nil
+}
+
+// fail counts a failed attempt and schedules the next one
+func fail(entry *Entry, cause error, now time.Time) {
+	entry.Attempts++
+	entry.LastError = cause.Error()
+	entry.NextAttempt = now.Add(RetryDelay(entry.Attempts))
+}
+
+// RetryDelay is the wait after the given number of failed attempts
+func RetryDelay(attempts int) time.Duration {
+	delay := MinRetryDelay
+	for i := 1; i < attempts && delay < MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, MaxRetryDelay)
+}
+
+// ImportDir returns the directory of the outbox used by backup imports
+// for the outbox directory dir of the bot
+func ImportDir(dir string) string {
+	return filepath.Join(dir, importSubdir)
+}
+
+// Pending returns the pending entries of the outbox in dir. It reads the
+// file without locking the directory, so it works while a process has the
+// outbox open.
+func Pending(dir string) ([]Entry, error) {
+	return readEntries(filepath.Join(dir, pendingFile))
+}
+
+// DeadLetters returns the dead letters of the outbox in dir
+func DeadLetters(dir string) ([]Entry, error) {
+	return readEntries(filepath.Join(dir, deadLetterFile))
+}
+
+// Replay writes the dead letters of the outbox in dir whose ID is in ids,
+// or all of them if ids is empty. Written entries are removed from the
+// dead-letter file and counted as Stored, failed ones stay with their new
+// error and are counted as Dead. Like Open, it needs the directory to itself.
+func Replay(ctx context.Context, dir string, ids []qdrant.PointID, write func(context.Context, Entry) error) (FlushResult, error) {
+	lock, err := lockDir(dir)
+	if err != nil {
+		return FlushResult{}, err
+	}
+	defer lock.Close()
+
+	path := filepath.Join(dir, deadLetterFile)
+	entries, err := readEntries(path)
+	if err != nil {
+		return FlushResult{}, err
+	}
+
+	selected := make(map[qdrant.PointID]bool, len(ids))
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	var result FlushResult
+	remaining := entries[:0:0]
+	for _, entry := range entries {
+		if len(selected) > 0 && !selected[entry.ID] || ctx.Err() != nil {
+			remaining = append(remaining, entry)
+			continue
+		}
+		if err := write(ctx, entry); err != nil {
+			fail(&entry, err, time.Now())
+			remaining = append(remaining, entry)
+			result.Dead++
+			continue
+		}
+		result.Stored++
+	}
+	if err := writeEntries(path, remaining); err != nil {
+		return result, err
+	}
+	return result, ctx.Err()
+}
+
+// readEntries reads a JSON-lines file of entries, a missing file is empty.
+// A partially written last line, as left behind by a crash, is ignored.
+func readEntries(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		// Numbers stay exact, payloads carry int64 message IDs and timestamps
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.UseNumber()
+		var entry Entry
+		if err := decoder.Decode(&entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return entries, nil
+}
+
+// appendEntry appends one entry to a JSON-lines file and syncs it
+func appendEntry(path string, entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	return file.Sync()
+}
+
+// writeEntries atomically replaces a JSON-lines file with the given entries
+func writeEntries(path string, entries []Entry) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file for %s: %v", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			tmp.Close()
+			return fmt.Errorf("error writing %s: %v", tmp.Name(), err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing %s: %v", tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error syncing %s: %v", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error replacing %s: %v", path, err)
+	}
+	return nil
+}
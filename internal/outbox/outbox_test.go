@@ -0,0 +1,176 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+)
+
+func newEntry(id string) Entry {
+	return Entry{
+		ID:      qdrant.PointID(id),
+		Text:    "alice: hello",
+		Payload: map[string]interface{}{"text": "alice: hello", "last_message_id": int64(9007199254740993)},
+	}
+}
+
+func TestOutbox_RetriesUntilStored(t *testing.T) {
+	dir := t.TempDir()
+	box, err := Open(dir, 3)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	now := time.Date(2025, 3, 5, 12, 0, 0, 0, time.UTC)
+	box.now = func() time.Time { return now }
+
+	if err := box.Add(newEntry("1"), errors.New("qdrant down")); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	// Nothing is due before the retry delay has passed
+	write := func(ctx context.Context, entry Entry) error { return nil }
+	result, err := box.Flush(context.Background(), write)
+	if err != nil || result != (FlushResult{}) {
+		t.Errorf("Expected nothing to be due, got %+v, %v", result, err)
+	}
+
+	// The outbox survives a restart, numbers in payloads stay exact
+	box.Close()
+	reopened, err := Open(dir, 3)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	entries := reopened.Entries()
+	if len(entries) != 1 || entries[0].Attempts != 1 || entries[0].LastError != "qdrant down" {
+		t.Fatalf("Unexpected entries after reopening: %+v", entries)
+	}
+	if id := entries[0].Payload["last_message_id"]; id != json.Number("9007199254740993") {
+		t.Errorf("last_message_id = %v, want 9007199254740993", id)
+	}
+
+	reopened.now = func() time.Time { return now.Add(MinRetryDelay) }
+	result, err = reopened.Flush(context.Background(), write)
+	if err != nil || result.Stored != 1 || reopened.Len() != 0 {
+		t.Errorf("Expected the entry to be stored, got %+v, %v with %d left", result, err, reopened.Len())
+	}
+}
+
+func TestOutbox_DeadLetters(t *testing.T) {
+	dir := t.TempDir()
+	box, _ := Open(dir, 3)
+	box.Add(newEntry("1"), errors.New("first"))
+	box.Add(newEntry("2"), errors.New("first"))
+
+	failing := func(ctx context.Context, entry Entry) error { return errors.New("still down") }
+	result, err := box.FlushAll(context.Background(), failing)
+	if err != nil || result.Retrying != 2 {
+		t.Errorf("Unexpected result %+v, %v", result, err)
+	}
+	result, err = box.FlushAll(context.Background(), failing)
+	if err != nil || result.Dead != 2 || box.Len() != 0 {
+		t.Errorf("Expected both entries to become dead letters, got %+v, %v with %d left", result, err, box.Len())
+	}
+
+	box.Close()
+
+	dead, err := DeadLetters(dir)
+	if err != nil || len(dead) != 2 || dead[0].Attempts != 3 || dead[0].LastError != "still down" {
+		t.Fatalf("Unexpected dead letters %+v, %v", dead, err)
+	}
+
+	var written []qdrant.PointID
+	result, err = Replay(context.Background(), dir, []qdrant.PointID{"2"}, func(ctx context.Context, entry Entry) error {
+		written = append(written, entry.ID)
+		return nil
+	})
+	if err != nil || result.Stored != 1 || len(written) != 1 || written[0] != "2" {
+		t.Errorf("Expected only entry 2 to be replayed, got %+v, %v, %v", result, err, written)
+	}
+	dead, _ = DeadLetters(dir)
+	if len(dead) != 1 || dead[0].ID != "1" {
+		t.Errorf("Expected entry 1 to remain a dead letter, got %+v", dead)
+	}
+}
+
+func TestOutbox_LocksDirectory(t *testing.T) {
+	dir := t.TempDir()
+	box, err := Open(dir, 3)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	// A second user would overwrite the first one's entries
+	if _, err := Open(dir, 3); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked while the outbox is open, got %v", err)
+	}
+	write := func(ctx context.Context, entry Entry) error { return nil }
+	if _, err := Replay(context.Background(), dir, nil, write); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked from Replay while the outbox is open, got %v", err)
+	}
+
+	// Reading the pending entries does not need the lock
+	box.Add(newEntry("1"), errors.New("down"))
+	pending, err := Pending(dir)
+	if err != nil || len(pending) != 1 || pending[0].ID != "1" {
+		t.Errorf("Pending = %v, %v, want entry 1", pending, err)
+	}
+
+	// Imports have a directory of their own
+	imports, err := Open(ImportDir(dir), 3)
+	if err != nil {
+		t.Fatalf("Open of the import outbox failed: %v", err)
+	}
+	imports.Close()
+
+	box.Close()
+	reopened, err := Open(dir, 3)
+	if err != nil {
+		t.Fatalf("Open after Close failed: %v", err)
+	}
+	reopened.Close()
+}
+
+func TestOutbox_AddReplacesSameID(t *testing.T) {
+	box, _ := Open(t.TempDir(), 3)
+	box.Add(newEntry("1"), errors.New("first"))
+	updated := newEntry("1")
+	updated.Text = "alice: hello\nbob: hi"
+	box.Add(updated, errors.New("second"))
+
+	entries := box.Entries()
+	if len(entries) != 1 || entries[0].Text != updated.Text || entries[0].Attempts != 2 {
+		t.Errorf("Unexpected entries %+v", entries)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{7, MaxRetryDelay},
+		{100, MaxRetryDelay},
+	}
+	for _, test := range tests {
+		if got := RetryDelay(test.attempts); got != test.want {
+			t.Errorf("RetryDelay(%d) = %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestEntry_Point(t *testing.T) {
+	point := newEntry("1").Point([]float32{1, 0}, true)
+	if len(point.Vector["data"].Dense) != 2 || point.Vector["text"].Sparse == nil {
+		t.Errorf("Unexpected vectors %+v", point.Vector)
+	}
+	if point := newEntry("1").Point([]float32{1, 0}, false); len(point.Vector) != 1 {
+		t.Errorf("Expected no keyword vector, got %+v", point.Vector)
+	}
+}
@@ -0,0 +1,42 @@
+// Package pointid derives deterministic Qdrant point IDs for chat chunks,
+// so storing the same chunk twice overwrites the existing point.
+package pointid
+
+import (
+	"crypto/sha1"
+	"fmt"
+)
+
+// namespace is the UUID namespace for chunk IDs of this project
+var namespace = [16]byte{
+	0x6b, 0x1f, 0x3c, 0x52, 0x8e, 0x0a, 0x4d, 0x7e,
+	0x9c, 0x21, 0x5a, 0x43, 0xd8, 0x7f, 0x10, 0xb6,
+}
+
+// ForChunk returns a UUIDv5 (RFC 4122) for the chunk of chat chatID that
+// spans Telegram message IDs firstMessageID to lastMessageID.
+//
+// The ID only matches when the chunk boundaries do. The live bot cuts
+// chunks by buffer size and idle time, the backup importer by the chunking
+// limits and time gaps, so importing an export that covers messages the bot
+// already stored adds overlapping points rather than overwriting them.
+// Re-running the same import, or the bot re-storing its own chunk, does
+// overwrite.
+func ForChunk(chatID, firstMessageID, lastMessageID int64) string {
+	name := fmt.Sprintf("%d:%d-%d", chatID, firstMessageID, lastMessageID)
+	return uuidV5(namespace, name)
+}
+
+func uuidV5(ns [16]byte, name string) string {
+	h := sha1.New()
+	h.Write(ns[:])
+	h.Write([]byte(name))
+	sum := h.Sum(nil)
+
+	var u [16]byte
+	copy(u[:], sum[:16])
+	u[6] = (u[6] & 0x0f) | 0x50 // Version 5
+	u[8] = (u[8] & 0x3f) | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
@@ -0,0 +1,210 @@
+// Package qdrant is a small typed client for the parts of the Qdrant HTTP
+// API used by the bot and the backup importer.
+package qdrant
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds every request of a client created without its own
+// http.Client
+const DefaultTimeout = 30 * time.Second
+
+// Error is a non-2xx response from Qdrant
+type Error struct {
+	StatusCode int
+	Message    string // Qdrant's error message, or the raw body if there is none
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("error response from Qdrant (status %d): %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound returns true if err is a Qdrant 404, e.g. a missing collection
+func IsNotFound(err error) bool {
+	var qerr *Error
+	return errors.As(err, &qerr) && qerr.StatusCode == http.StatusNotFound
+}
+
+// Client talks to a single Qdrant instance. It is safe for concurrent use.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient returns a client for the Qdrant HTTP API at baseURL, e.g.
+// "http://localhost:6333". If httpClient is nil, a client with
+// DefaultTimeout is used.
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultTimeout}
+	}
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    httpClient,
+	}
+}
+
+// BaseURL returns the address of the Qdrant HTTP API
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// GetCollection returns the configuration of a collection. A missing
+// collection is reported as an error for which IsNotFound is true.
+func (c *Client) GetCollection(ctx context.Context, name string) (*CollectionInfo, error) {
+	var info CollectionInfo
+	if err := c.do(ctx, http.MethodGet, "/collections/"+url.PathEscape(name), nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// CreateCollection creates a collection with the given vector layout
+func (c *Client) CreateCollection(ctx context.Context, name string, params CollectionParams) error {
+	return c.do(ctx, http.MethodPut, "/collections/"+url.PathEscape(name), params, nil)
+}
+
+// DeleteCollection deletes a collection and all its points
+func (c *Client) DeleteCollection(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/collections/"+url.PathEscape(name), nil, nil)
+}
+
+// CreatePayloadIndex indexes a payload field, schema is e.g. "keyword" or
+// "integer". Creating an index that already exists succeeds.
+func (c *Client) CreatePayloadIndex(ctx context.Context, collection, field, schema string) error {
+	body := PayloadIndexRequest{FieldName: field, FieldSchema: schema}
+	return c.do(ctx, http.MethodPut, "/collections/"+url.PathEscape(collection)+"/index", body, nil)
+}
+
+// Upsert writes points, replacing existing points with the same IDs. With
+// wait set it returns only once Qdrant has applied the change.
+func (c *Client) Upsert(ctx context.Context, collection string, points []Point, wait bool) error {
+	path := "/collections/" + url.PathEscape(collection) + "/points"
+	if wait {
+		path += "?wait=true"
+	}
+	return c.do(ctx, http.MethodPut, path, upsertRequest{Points: points}, nil)
+}
+
+// Search returns the points closest to the query vector
+func (c *Client) Search(ctx context.Context, collection string, req SearchRequest) ([]ScoredPoint, error) {
+	var points []ScoredPoint
+	if err := c.do(ctx, http.MethodPost, "/collections/"+url.PathEscape(collection)+"/points/search", req, &points); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// Scroll returns a page of points in ID order
+func (c *Client) Scroll(ctx context.Context, collection string, req ScrollRequest) (*ScrollResult, error) {
+	var result ScrollResult
+	if err := c.do(ctx, http.MethodPost, "/collections/"+url.PathEscape(collection)+"/points/scroll", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Retrieve returns the points with the given IDs that exist in a collection
+func (c *Client) Retrieve(ctx context.Context, collection string, req RetrieveRequest) ([]Record, error) {
+	var records []Record
+	if err := c.do(ctx, http.MethodPost, "/collections/"+url.PathEscape(collection)+"/points", req, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Count returns the exact number of points in a collection
+func (c *Client) Count(ctx context.Context, collection string) (int64, error) {
+	var result countResult
+	if err := c.do(ctx, http.MethodPost, "/collections/"+url.PathEscape(collection)+"/points/count", countRequest{Exact: true}, &result); err != nil {
+		return 0, err
+	}
+	return result.Count, nil
+}
+
+// ListAliases returns all aliases of the Qdrant instance
+func (c *Client) ListAliases(ctx context.Context) ([]Alias, error) {
+	var result aliasesResult
+	if err := c.do(ctx, http.MethodGet, "/aliases", nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Aliases, nil
+}
+
+// UpdateAliases applies all actions atomically, e.g. deleting an alias and
+// creating it for another collection switches readers over at once
+func (c *Client) UpdateAliases(ctx context.Context, actions ...AliasAction) error {
+	return c.do(ctx, http.MethodPost, "/collections/aliases", aliasesRequest{Actions: actions}, nil)
+}
+
+// do sends a request and decodes the "result" field of the response into
+// result, unless result is nil
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		requestBody, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error marshaling Qdrant request: %w", err)
+		}
+		reader = bytes.NewReader(requestBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &Error{StatusCode: resp.StatusCode, Message: errorMessage(respBody)}
+	}
+	if result == nil {
+		return nil
+	}
+
+	var envelope response
+	if err := json.Unmarshal(respBody, &envelope); err != nil {
+		return fmt.Errorf("error unmarshaling Qdrant response: %w", err)
+	}
+	if err := json.Unmarshal(envelope.Result, result); err != nil {
+		return fmt.Errorf("error unmarshaling Qdrant result: %w", err)
+	}
+	return nil
+}
+
+// errorMessage extracts status.error from an error response
+func errorMessage(body []byte) string {
+	var envelope response
+	if err := json.Unmarshal(body, &envelope); err == nil {
+		var status struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(envelope.Status, &status) == nil && status.Error != "" {
+			return status.Error
+		}
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,121 @@
+package qdrant
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/korjavin/ragtgbot/internal/sparse"
+)
+
+func TestPointID_JSON(t *testing.T) {
+	var ids []PointID
+	if err := json.Unmarshal([]byte(`[7, "6b1f3c52-8e0a-5d7e-9c21-5a43d87f10b6"]`), &ids); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if ids[0] != "7" || ids[1] != "6b1f3c52-8e0a-5d7e-9c21-5a43d87f10b6" {
+		t.Errorf("Unexpected IDs: %v", ids)
+	}
+
+	encoded, err := json.Marshal(ids)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(encoded) != `[7,"6b1f3c52-8e0a-5d7e-9c21-5a43d87f10b6"]` {
+		t.Errorf("Marshal = %s", encoded)
+	}
+}
+
+func TestVector_JSON(t *testing.T) {
+	vectors := NamedVectors{
+		"data": DenseVector([]float32{0.5, 1}),
+		"text": SparseVector(sparse.Vector{Indices: []uint32{3}, Values: []float32{2}}),
+	}
+	encoded, err := json.Marshal(vectors)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(encoded) != `{"data":[0.5,1],"text":{"indices":[3],"values":[2]}}` {
+		t.Errorf("Marshal = %s", encoded)
+	}
+
+	var decoded NamedVectors
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if len(decoded["data"].Dense) != 2 || decoded["text"].Sparse == nil || decoded["text"].Sparse.Indices[0] != 3 {
+		t.Errorf("Unexpected vectors: %+v", decoded)
+	}
+}
+
+func TestVectorsConfig_Unnamed(t *testing.T) {
+	var config VectorsConfig
+	if err := json.Unmarshal([]byte(`{"size": 512, "distance": "Cosine"}`), &config); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if _, named := config["data"]; named || config[""].Size != 512 {
+		t.Errorf("Unexpected config: %v", config)
+	}
+
+	if err := json.Unmarshal([]byte(`{"data": {"size": 384, "distance": "Cosine"}}`), &config); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if config["data"].Size != 384 {
+		t.Errorf("Unexpected config: %v", config)
+	}
+}
+
+func TestClient_Search(t *testing.T) {
+	var got SearchRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/collections/chat_history/points/search" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"result": [{"id": "a", "version": 1, "score": 0.9,
+			"payload": {"text": "hello"}, "vector": {"data": [1, 0]}}], "status": "ok", "time": 0.001}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL+"/", nil)
+	points, err := client.Search(context.Background(), "chat_history", SearchRequest{
+		Vector: NamedVectorQuery{Name: "data", Vector: DenseVector([]float32{1, 0})},
+		Filter: &Filter{Must: []Condition{
+			FieldMatch("chat_id", "-100"),
+			FieldRange("last_timestamp", Range{GTE: Bound(10)}),
+		}},
+		Limit:       5,
+		WithPayload: true,
+	})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+
+	if got.Limit != 5 || len(got.Filter.Must) != 2 || *got.Filter.Must[1].Range.GTE != 10 {
+		t.Errorf("Unexpected request: %+v", got)
+	}
+	if len(points) != 1 || points[0].ID != "a" || points[0].PayloadString("text") != "hello" {
+		t.Fatalf("Unexpected points: %+v", points)
+	}
+	if len(points[0].Vector["data"].Dense) != 2 {
+		t.Errorf("Expected the data vector, got %+v", points[0].Vector)
+	}
+}
+
+func TestClient_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"status": {"error": "Not found: Collection missing doesn't exist!"}, "time": 0}`))
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL, nil).GetCollection(context.Background(), "missing")
+	if !IsNotFound(err) {
+		t.Fatalf("Expected a not found error, got %v", err)
+	}
+	if qerr := err.(*Error); qerr.Message != "Not found: Collection missing doesn't exist!" {
+		t.Errorf("Unexpected message: %q", qerr.Message)
+	}
+}
@@ -0,0 +1,327 @@
+package qdrant
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/korjavin/ragtgbot/internal/sparse"
+)
+
+// PointID is a Qdrant point ID. Qdrant accepts unsigned integers and UUIDs;
+// both are kept as their string form here and numeric IDs are sent back as
+// JSON numbers.
+type PointID string
+
+// MarshalJSON implements json.Marshaler
+func (id PointID) MarshalJSON() ([]byte, error) {
+	if _, err := strconv.ParseUint(string(id), 10, 64); err == nil {
+		return []byte(id), nil
+	}
+	return json.Marshal(string(id))
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (id *PointID) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*id = PointID(s)
+		return nil
+	}
+	if _, err := strconv.ParseUint(string(data), 10, 64); err != nil {
+		return fmt.Errorf("invalid point ID %s", string(data))
+	}
+	*id = PointID(data)
+	return nil
+}
+
+// Vector is either a dense or a sparse vector
+type Vector struct {
+	Dense  []float32
+	Sparse *sparse.Vector
+}
+
+// DenseVector wraps a dense embedding
+func DenseVector(values []float32) Vector {
+	return Vector{Dense: values}
+}
+
+// SparseVector wraps a sparse keyword vector
+func SparseVector(v sparse.Vector) Vector {
+	return Vector{Sparse: &v}
+}
+
+// MarshalJSON implements json.Marshaler
+func (v Vector) MarshalJSON() ([]byte, error) {
+	if v.Sparse != nil {
+		return json.Marshal(v.Sparse)
+	}
+	return json.Marshal(v.Dense)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (v *Vector) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var s sparse.Vector
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*v = Vector{Sparse: &s}
+		return nil
+	}
+	var dense []float32
+	if err := json.Unmarshal(data, &dense); err != nil {
+		return err
+	}
+	*v = Vector{Dense: dense}
+	return nil
+}
+
+// NamedVectors holds the vectors of a point by name. A point of a
+// collection with a single unnamed vector has its vector under the name "".
+type NamedVectors map[string]Vector
+
+// MarshalJSON implements json.Marshaler
+func (v NamedVectors) MarshalJSON() ([]byte, error) {
+	if unnamed, ok := v[""]; ok && len(v) == 1 {
+		return json.Marshal(unnamed)
+	}
+	return json.Marshal(map[string]Vector(v))
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (v *NamedVectors) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var unnamed Vector
+		if err := unnamed.UnmarshalJSON(data); err != nil {
+			return err
+		}
+		*v = NamedVectors{"": unnamed}
+		return nil
+	}
+	var named map[string]Vector
+	if err := json.Unmarshal(data, &named); err != nil {
+		return err
+	}
+	*v = named
+	return nil
+}
+
+// Point is a point to be upserted
+type Point struct {
+	ID      PointID                `json:"id"`
+	Vector  NamedVectors           `json:"vector"`
+	Payload map[string]interface{} `json:"payload,omitempty"`
+}
+
+// ScoredPoint is a point found by a search
+type ScoredPoint struct {
+	ID      PointID                `json:"id"`
+	Version int64                  `json:"version"`
+	Score   float64                `json:"score"`
+	Payload map[string]interface{} `json:"payload,omitempty"`
+	Vector  NamedVectors           `json:"vector,omitempty"`
+}
+
+// Record is a point returned by a scroll or retrieve
+type Record struct {
+	ID      PointID                `json:"id"`
+	Payload map[string]interface{} `json:"payload,omitempty"`
+	Vector  NamedVectors           `json:"vector,omitempty"`
+}
+
+// PayloadString returns a string payload field, or "" if it is missing or
+// not a string
+func (p ScoredPoint) PayloadString(key string) string {
+	s, _ := p.Payload[key].(string)
+	return s
+}
+
+// Match matches a payload field against an exact value
+type Match struct {
+	Value interface{} `json:"value"`
+}
+
+// Range bounds a numeric payload field, nil bounds are open
+type Range struct {
+	GT  *float64 `json:"gt,omitempty"`
+	GTE *float64 `json:"gte,omitempty"`
+	LT  *float64 `json:"lt,omitempty"`
+	LTE *float64 `json:"lte,omitempty"`
+}
+
+// Condition is a single field condition of a filter
+type Condition struct {
+	Key   string `json:"key"`
+	Match *Match `json:"match,omitempty"`
+	Range *Range `json:"range,omitempty"`
+}
+
+// FieldMatch returns a condition matching key against value
+func FieldMatch(key string, value interface{}) Condition {
+	return Condition{Key: key, Match: &Match{Value: value}}
+}
+
+// FieldRange returns a condition restricting key to r
+func FieldRange(key string, r Range) Condition {
+	return Condition{Key: key, Range: &r}
+}
+
+// Bound returns a pointer to v, for use in Range
+func Bound(v float64) *float64 {
+	return &v
+}
+
+// Filter restricts a search to points matching all Must conditions
+type Filter struct {
+	Must []Condition `json:"must,omitempty"`
+}
+
+// NamedVectorQuery is the query vector of a search and the name of the
+// collection vector it is compared with
+type NamedVectorQuery struct {
+	Name   string `json:"name"`
+	Vector Vector `json:"vector"`
+}
+
+// SearchRequest is the body of a points search
+type SearchRequest struct {
+	Vector      NamedVectorQuery `json:"vector"`
+	Filter      *Filter          `json:"filter,omitempty"`
+	Limit       int              `json:"limit"`
+	WithPayload bool             `json:"with_payload"`
+	WithVector  []string         `json:"with_vector,omitempty"` // Names of the vectors to return
+}
+
+// VectorParams configures a dense vector of a collection
+type VectorParams struct {
+	Size     int    `json:"size"`
+	Distance string `json:"distance"`
+}
+
+// SparseVectorParams configures a sparse vector of a collection
+type SparseVectorParams struct {
+	Modifier string `json:"modifier,omitempty"` // "idf" lets Qdrant add the IDF part of BM25
+}
+
+// VectorsConfig holds the dense vectors of a collection by name. A
+// collection with a single unnamed vector is stored under the name "".
+type VectorsConfig map[string]VectorParams
+
+// MarshalJSON implements json.Marshaler
+func (c VectorsConfig) MarshalJSON() ([]byte, error) {
+	if unnamed, ok := c[""]; ok && len(c) == 1 {
+		return json.Marshal(unnamed)
+	}
+	return json.Marshal(map[string]VectorParams(c))
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (c *VectorsConfig) UnmarshalJSON(data []byte) error {
+	var unnamed VectorParams
+	if err := json.Unmarshal(data, &unnamed); err == nil && unnamed.Size > 0 {
+		*c = VectorsConfig{"": unnamed}
+		return nil
+	}
+	var named map[string]VectorParams
+	if err := json.Unmarshal(data, &named); err != nil {
+		return err
+	}
+	*c = named
+	return nil
+}
+
+// CollectionParams is the vector layout of a collection
+type CollectionParams struct {
+	Vectors       VectorsConfig                 `json:"vectors"`
+	SparseVectors map[string]SparseVectorParams `json:"sparse_vectors,omitempty"`
+}
+
+// CollectionInfo describes an existing collection
+type CollectionInfo struct {
+	Status      string `json:"status"`
+	PointsCount int64  `json:"points_count"`
+	Config      struct {
+		Params CollectionParams `json:"params"`
+	} `json:"config"`
+}
+
+// PayloadIndexRequest is the body of a payload index creation
+type PayloadIndexRequest struct {
+	FieldName   string `json:"field_name"`
+	FieldSchema string `json:"field_schema"` // "keyword", "integer", ...
+}
+
+// ScrollRequest is the body of a points scroll. Offset is the
+// NextPageOffset of the previous page, nil for the first page.
+type ScrollRequest struct {
+	Limit       int      `json:"limit"`
+	Offset      *PointID `json:"offset,omitempty"`
+	Filter      *Filter  `json:"filter,omitempty"`
+	WithPayload bool     `json:"with_payload"`
+	WithVector  bool     `json:"with_vector"`
+}
+
+// RetrieveRequest is the body of a points lookup by ID
+type RetrieveRequest struct {
+	IDs         []PointID `json:"ids"`
+	WithPayload bool      `json:"with_payload"`
+	WithVector  bool      `json:"with_vector"`
+}
+
+// ScrollResult is a page of points. NextPageOffset is nil on the last page.
+type ScrollResult struct {
+	Points         []Record `json:"points"`
+	NextPageOffset *PointID `json:"next_page_offset"`
+}
+
+// Alias maps an alias name to a collection
+type Alias struct {
+	AliasName      string `json:"alias_name"`
+	CollectionName string `json:"collection_name"`
+}
+
+// AliasAction is one step of an atomic alias update, exactly one field is set
+type AliasAction struct {
+	CreateAlias *Alias       `json:"create_alias,omitempty"`
+	DeleteAlias *DeleteAlias `json:"delete_alias,omitempty"`
+}
+
+// DeleteAlias removes an alias
+type DeleteAlias struct {
+	AliasName string `json:"alias_name"`
+}
+
+type aliasesRequest struct {
+	Actions []AliasAction `json:"actions"`
+}
+
+type aliasesResult struct {
+	Aliases []Alias `json:"aliases"`
+}
+
+type countRequest struct {
+	Exact bool `json:"exact"`
+}
+
+type countResult struct {
+	Count int64 `json:"count"`
+}
+
+type upsertRequest struct {
+	Points []Point `json:"points"`
+}
+
+// response is the envelope of every Qdrant response. On errors Status is an
+// object with an "error" field instead of "ok".
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Status json.RawMessage `json:"status"`
+}
@@ -0,0 +1,91 @@
+package rank
+
+import (
+	"math"
+
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+)
+
+// DefaultMMRLambda weighs relevance against diversity in MMR, 1 means
+// relevance only
+const DefaultMMRLambda = 0.7
+
+// MMR selects k results with Maximal Marginal Relevance: each step picks the
+// result maximising lambda*rel(d) - (1-lambda)*max sim(d, selected).
+// Relevance comes from the position in results, so the order set by fusion
+// and reranking is kept; their scores are on different scales. Similarities
+// are cosine similarities of the named dense vector returned by Qdrant
+// (search with WithVector). Results without that vector are never
+// considered redundant.
+func MMR(results []qdrant.ScoredPoint, vectorName string, lambda float64, k int) []qdrant.ScoredPoint {
+	if k <= 0 || k > len(results) {
+		k = len(results)
+	}
+
+	vectors := make([][]float64, len(results))
+	relevance := make([]float64, len(results))
+	for i, result := range results {
+		vectors[i] = denseVector(result, vectorName)
+		relevance[i] = 1 - float64(i)/float64(len(results))
+	}
+
+	selected := make([]int, 0, k)
+	used := make([]bool, len(results))
+	for len(selected) < k {
+		best, bestScore := -1, math.Inf(-1)
+		for i := range results {
+			if used[i] {
+				continue
+			}
+			redundancy := 0.0
+			for _, j := range selected {
+				if vectors[i] == nil || vectors[j] == nil {
+					continue
+				}
+				redundancy = math.Max(redundancy, cosine(vectors[i], vectors[j]))
+			}
+			score := lambda*relevance[i] - (1-lambda)*redundancy
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		used[best] = true
+		selected = append(selected, best)
+	}
+
+	diversified := make([]qdrant.ScoredPoint, len(selected))
+	for i, idx := range selected {
+		diversified[i] = results[idx]
+	}
+	return diversified
+}
+
+// denseVector returns a named dense vector of a search result as float64
+func denseVector(result qdrant.ScoredPoint, name string) []float64 {
+	values := result.Vector[name].Dense
+	if len(values) == 0 {
+		return nil
+	}
+
+	vector := make([]float64, len(values))
+	for i, v := range values {
+		vector[i] = float64(v)
+	}
+	return vector
+}
+
+func cosine(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,85 @@
+package rank
+
+import (
+	"math"
+	"testing"
+
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+)
+
+func vectorResult(id qdrant.PointID, vector ...float32) qdrant.ScoredPoint {
+	return qdrant.ScoredPoint{
+		ID:     id,
+		Vector: qdrant.NamedVectors{"data": qdrant.DenseVector(vector)},
+	}
+}
+
+func ids(results []qdrant.ScoredPoint) []qdrant.PointID {
+	var list []qdrant.PointID
+	for _, r := range results {
+		list = append(list, r.ID)
+	}
+	return list
+}
+
+func TestMMR_Diversifies(t *testing.T) {
+	results := []qdrant.ScoredPoint{
+		vectorResult("busy-1", 0.9, 0.1, 0),
+		vectorResult("busy-2", 0.9, 0.11, 0), // Nearly identical to busy-1
+		vectorResult("other", 0, 0, 1),
+	}
+
+	got := ids(MMR(results, "data", 0.5, 2))
+	if got[0] != "busy-1" || got[1] != "other" {
+		t.Errorf("MMR picked %v, want [busy-1 other]", got)
+	}
+}
+
+func TestMMR_LambdaOneKeepsRelevanceOrder(t *testing.T) {
+	results := []qdrant.ScoredPoint{
+		vectorResult("busy-1", 0.9, 0.1, 0),
+		vectorResult("busy-2", 0.9, 0.11, 0),
+		vectorResult("other", 0.7, 0, 0.7),
+	}
+
+	got := ids(MMR(results, "data", 1, 2))
+	if got[0] != "busy-1" || got[1] != "busy-2" {
+		t.Errorf("MMR picked %v, want [busy-1 busy-2]", got)
+	}
+}
+
+func TestMMR_KeepsRerankedOrder(t *testing.T) {
+	// The reranker put the results in the opposite order of their dense
+	// similarity to the query (1, 0, 0)
+	results := []qdrant.ScoredPoint{
+		vectorResult("reranked-1", 0.1, 0.9, 0),
+		vectorResult("reranked-2", 0.5, 0, 0.5),
+		vectorResult("reranked-3", 1, 0, 0),
+	}
+
+	got := ids(MMR(results, "data", 1, 3))
+	if got[0] != "reranked-1" || got[1] != "reranked-2" || got[2] != "reranked-3" {
+		t.Errorf("MMR picked %v, want the reranked order", got)
+	}
+}
+
+func TestMMR_WithoutVectorsKeepsOrder(t *testing.T) {
+	results := []qdrant.ScoredPoint{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+
+	got := ids(MMR(results, "data", 0.5, 5))
+	if len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Errorf("MMR picked %v, want [a b c]", got)
+	}
+}
+
+func TestCosine(t *testing.T) {
+	if c := cosine([]float64{1, 0}, []float64{0, 1}); c != 0 {
+		t.Errorf("cosine of orthogonal vectors = %f, want 0", c)
+	}
+	if c := cosine([]float64{1, 1}, []float64{2, 2}); math.Abs(c-1) > 1e-9 {
+		t.Errorf("cosine of parallel vectors = %f, want 1", c)
+	}
+	if c := cosine([]float64{1}, []float64{1, 2}); c != 0 {
+		t.Errorf("cosine of mismatched vectors = %f, want 0", c)
+	}
+}
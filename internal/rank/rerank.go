@@ -0,0 +1,169 @@
+package rank
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+	"github.com/korjavin/ragtgbot/internal/sparse"
+)
+
+// Reranker scores how well each document answers the query. Higher scores
+// are better, the scale is up to the implementation.
+type Reranker interface {
+	Score(ctx context.Context, query string, documents []string) ([]float64, error)
+}
+
+// Rerank orders results by the reranker's score of their payload text and
+// returns the best k. The reranker's score replaces the search score.
+func Rerank(ctx context.Context, reranker Reranker, query string, results []qdrant.ScoredPoint, k int) ([]qdrant.ScoredPoint, error) {
+	documents := make([]string, len(results))
+	for i, result := range results {
+		documents[i] = result.PayloadString("text")
+	}
+
+	scores, err := reranker.Score(ctx, query, documents)
+	if err != nil {
+		return nil, err
+	}
+	if len(scores) != len(results) {
+		return nil, fmt.Errorf("reranker returned %d scores for %d documents", len(scores), len(results))
+	}
+
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+	if k > 0 && len(order) > k {
+		order = order[:k]
+	}
+
+	reranked := make([]qdrant.ScoredPoint, len(order))
+	for i, idx := range order {
+		reranked[i] = results[idx]
+		reranked[i].Score = scores[idx]
+	}
+	return reranked, nil
+}
+
+// LexicalReranker scores documents by the share of distinct query terms
+// they contain. It needs no model and serves as a fallback.
+type LexicalReranker struct{}
+
+// Score implements Reranker
+func (LexicalReranker) Score(_ context.Context, query string, documents []string) ([]float64, error) {
+	queryTerms := distinctTerms(query)
+	scores := make([]float64, len(documents))
+	if len(queryTerms) == 0 {
+		return scores, nil
+	}
+
+	for i, document := range documents {
+		documentTerms := distinctTerms(document)
+		matched := 0
+		for term := range queryTerms {
+			if documentTerms[term] {
+				matched++
+			}
+		}
+		scores[i] = float64(matched) / float64(len(queryTerms))
+	}
+	return scores, nil
+}
+
+func distinctTerms(text string) map[string]bool {
+	terms := make(map[string]bool)
+	for _, token := range sparse.Tokenize(text) {
+		terms[token] = true
+	}
+	return terms
+}
+
+// CrossEncoderReranker calls the embedding service's /rerank endpoint,
+// which scores every (query, document) pair with a cross-encoder model
+type CrossEncoderReranker struct {
+	URL    string
+	Client *http.Client
+}
+
+type rerankRequest struct {
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+}
+
+type rerankResponse struct {
+	Scores []float64 `json:"scores"`
+}
+
+// Score implements Reranker
+func (r CrossEncoderReranker) Score(ctx context.Context, query string, documents []string) ([]float64, error) {
+	if len(documents) == 0 {
+		return nil, nil
+	}
+
+	requestBody, err := json.Marshal(rerankRequest{Query: query, Documents: documents})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error response from rerank service (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	var response rerankResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("error unmarshaling rerank response: %v", err)
+	}
+	return response.Scores, nil
+}
+
+// FallbackReranker uses Primary and switches to Fallback for a request
+// when Primary fails
+type FallbackReranker struct {
+	Primary  Reranker
+	Fallback Reranker
+	OnError  func(error) // Optional, e.g. for logging
+}
+
+// Score implements Reranker
+func (r FallbackReranker) Score(ctx context.Context, query string, documents []string) ([]float64, error) {
+	scores, err := r.Primary.Score(ctx, query, documents)
+	if err == nil && len(scores) == len(documents) {
+		return scores, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("reranker returned %d scores for %d documents", len(scores), len(documents))
+	}
+	if r.OnError != nil {
+		r.OnError(err)
+	}
+	return r.Fallback.Score(ctx, query, documents)
+}
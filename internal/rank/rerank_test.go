@@ -0,0 +1,104 @@
+package rank
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+)
+
+func textResults(texts ...string) []qdrant.ScoredPoint {
+	list := make([]qdrant.ScoredPoint, len(texts))
+	for i, text := range texts {
+		list[i] = qdrant.ScoredPoint{ID: qdrant.PointID(strconv.Itoa(i)), Payload: map[string]interface{}{"text": text}}
+	}
+	return list
+}
+
+func TestLexicalReranker(t *testing.T) {
+	results := textResults(
+		"we talked about lunch",
+		"the deploy failed with ERR-4012 on staging",
+		"staging is down again",
+	)
+
+	reranked, err := Rerank(context.Background(), LexicalReranker{}, "why did staging fail with ERR-4012", results, 2)
+	if err != nil {
+		t.Fatalf("Rerank failed: %v", err)
+	}
+	if len(reranked) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(reranked))
+	}
+	if reranked[0].ID != "1" || reranked[1].ID != "2" {
+		t.Errorf("Unexpected order: %v, %v", reranked[0].ID, reranked[1].ID)
+	}
+	if results[1].Score != 0 {
+		t.Error("Rerank should not modify the input results")
+	}
+}
+
+type failingReranker struct{}
+
+func (failingReranker) Score(context.Context, string, []string) ([]float64, error) {
+	return nil, errors.New("service down")
+}
+
+func TestFallbackReranker(t *testing.T) {
+	var reported error
+	reranker := FallbackReranker{
+		Primary:  failingReranker{},
+		Fallback: LexicalReranker{},
+		OnError:  func(err error) { reported = err },
+	}
+
+	scores, err := reranker.Score(context.Background(), "staging", []string{"lunch", "staging down"})
+	if err != nil {
+		t.Fatalf("Score failed: %v", err)
+	}
+	if reported == nil {
+		t.Error("Primary error should be reported")
+	}
+	if scores[0] != 0 || scores[1] != 1 {
+		t.Errorf("Fallback scores = %v, want [0 1]", scores)
+	}
+}
+
+func TestCrossEncoderReranker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req rerankRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Error decoding request: %v", err)
+		}
+		if req.Query != "question" || len(req.Documents) != 2 {
+			t.Errorf("Unexpected request: %+v", req)
+		}
+		json.NewEncoder(w).Encode(rerankResponse{Scores: []float64{-1.5, 3.2}})
+	}))
+	defer server.Close()
+
+	reranker := CrossEncoderReranker{URL: server.URL}
+	reranked, err := Rerank(context.Background(), reranker, "question", textResults("first", "second"), 5)
+	if err != nil {
+		t.Fatalf("Rerank failed: %v", err)
+	}
+	if reranked[0].ID != "1" || reranked[0].Score != 3.2 {
+		t.Errorf("Unexpected top result: %v", reranked[0])
+	}
+}
+
+func TestCrossEncoderReranker_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not loaded", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := CrossEncoderReranker{URL: server.URL}.Score(context.Background(), "q", []string{"doc"})
+	if err == nil {
+		t.Error("Expected an error for a non-200 response")
+	}
+}
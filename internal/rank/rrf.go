@@ -0,0 +1,47 @@
+// Package rank combines and reorders Qdrant search results before they are
+// handed to the language model.
+package rank
+
+import (
+	"sort"
+
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+)
+
+// DefaultRRFK is the usual constant of Reciprocal Rank Fusion, it dampens
+// the influence of the very first ranks
+const DefaultRRFK = 60
+
+// FuseRRF merges several ranked result lists with Reciprocal Rank Fusion:
+// each result scores the sum of 1/(k+rank) over the lists it appears in.
+// Results are matched by their point ID. The fused score replaces the
+// search score, and at most limit results are returned.
+func FuseRRF(lists [][]qdrant.ScoredPoint, k int, limit int) []qdrant.ScoredPoint {
+	scores := make(map[qdrant.PointID]float64)
+	results := make(map[qdrant.PointID]qdrant.ScoredPoint)
+	var order []qdrant.PointID // First appearance, keeps ties stable
+
+	for _, list := range lists {
+		for rank, result := range list {
+			if _, seen := results[result.ID]; !seen {
+				results[result.ID] = result
+				order = append(order, result.ID)
+			}
+			scores[result.ID] += 1.0 / float64(k+rank+1)
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+	if limit > 0 && len(order) > limit {
+		order = order[:limit]
+	}
+
+	fused := make([]qdrant.ScoredPoint, len(order))
+	for i, id := range order {
+		fused[i] = results[id]
+		fused[i].Score = scores[id]
+	}
+	return fused
+}
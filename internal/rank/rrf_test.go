@@ -0,0 +1,67 @@
+package rank
+
+import (
+	"testing"
+
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+)
+
+func results(ids ...qdrant.PointID) []qdrant.ScoredPoint {
+	list := make([]qdrant.ScoredPoint, len(ids))
+	for i, id := range ids {
+		list[i] = qdrant.ScoredPoint{ID: id, Score: 0.5, Payload: map[string]interface{}{"text": string(id)}}
+	}
+	return list
+}
+
+func TestFuseRRF(t *testing.T) {
+	dense := results("a", "b", "c")
+	keyword := results("c", "d", "a")
+
+	fused := FuseRRF([][]qdrant.ScoredPoint{dense, keyword}, DefaultRRFK, 10)
+
+	var ids []qdrant.PointID
+	for _, r := range fused {
+		ids = append(ids, r.ID)
+	}
+	// "a" ranks 1st and 3rd, "c" 3rd and 1st: tie, "a" came first
+	want := []qdrant.PointID{"a", "c", "b", "d"}
+	if len(ids) != len(want) {
+		t.Fatalf("Fused ids = %v, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Errorf("Fused ids = %v, want %v", ids, want)
+			break
+		}
+	}
+
+	wantScore := 1.0/61 + 1.0/63
+	if score := fused[0].Score; score != wantScore {
+		t.Errorf("Fused score = %f, want %f", score, wantScore)
+	}
+	if dense[0].Score != 0.5 {
+		t.Error("FuseRRF should not modify the input results")
+	}
+}
+
+func TestFuseRRF_Limit(t *testing.T) {
+	fused := FuseRRF([][]qdrant.ScoredPoint{results("a", "b", "c"), results("d")}, DefaultRRFK, 2)
+	if len(fused) != 2 {
+		t.Errorf("Expected 2 results, got %d", len(fused))
+	}
+}
+
+func TestFuseRRF_NumericIDs(t *testing.T) {
+	// The same point from two lists must merge
+	fused := FuseRRF([][]qdrant.ScoredPoint{results("7"), results("7")}, DefaultRRFK, 10)
+	if len(fused) != 1 {
+		t.Errorf("Expected 1 merged result, got %d", len(fused))
+	}
+}
+
+func TestFuseRRF_Empty(t *testing.T) {
+	if fused := FuseRRF(nil, DefaultRRFK, 5); len(fused) != 0 {
+		t.Errorf("Expected no results, got %d", len(fused))
+	}
+}
@@ -0,0 +1,140 @@
+package resilient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the service while its
+// circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// State of a circuit breaker
+type State int
+
+const (
+	Closed   State = iota // Requests pass, failures are counted
+	Open                  // Requests fail fast until the cool-down is over
+	HalfOpen              // One trial request decides between Closed and Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Breaker opens after a number of consecutive failures and lets a single
+// trial request through once the cool-down has passed
+type Breaker struct {
+	mutex     sync.Mutex
+	threshold int
+	coolDown  time.Duration
+	onChange  func(from, to State)
+	now       func() time.Time
+
+	state    State
+	failures int
+	openedAt time.Time
+	trial    bool // A half-open trial request is in flight
+}
+
+// NewBreaker creates a closed breaker. onChange, if not nil, is called on
+// every state change, with the breaker locked.
+func NewBreaker(threshold int, coolDown time.Duration, onChange func(from, to State)) *Breaker {
+	return &Breaker{threshold: threshold, coolDown: coolDown, onChange: onChange, now: time.Now}
+}
+
+// State returns the current state, moving an open breaker whose cool-down
+// has passed to half-open
+func (b *Breaker) State() State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.checkCoolDown()
+	return b.state
+}
+
+// Allow returns ErrCircuitOpen if a request must not be sent. Every allowed
+// request has to be followed by Record or Release.
+func (b *Breaker) Allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.checkCoolDown()
+	switch b.state {
+	case Open:
+		return ErrCircuitOpen
+	case HalfOpen:
+		if b.trial {
+			return ErrCircuitOpen
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+// Record reports the outcome of an allowed request
+func (b *Breaker) Record(success bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == HalfOpen {
+		b.trial = false
+		if success {
+			b.failures = 0
+			b.setState(Closed)
+		} else {
+			b.open()
+		}
+		return
+	}
+
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == Closed && b.failures >= b.threshold {
+		b.open()
+	}
+}
+
+// Release ends an allowed request without an outcome, e.g. because the
+// caller gave up. A half-open breaker then admits another trial.
+func (b *Breaker) Release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state == HalfOpen {
+		b.trial = false
+	}
+}
+
+func (b *Breaker) open() {
+	b.openedAt = b.now()
+	b.setState(Open)
+}
+
+func (b *Breaker) checkCoolDown() {
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.coolDown {
+		b.setState(HalfOpen)
+	}
+}
+
+func (b *Breaker) setState(state State) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	if b.onChange != nil {
+		b.onChange(from, state)
+	}
+}
@@ -0,0 +1,243 @@
+// Package resilient makes outbound HTTP calls survive brief outages: every
+// attempt gets a timeout, failed attempts are retried with exponential
+// backoff and jitter, and a circuit breaker stops calling a service that
+// keeps failing. Each client publishes its breaker state and counters under
+// the "http_services" expvar.
+package resilient
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Policy configures the client of one service
+type Policy struct {
+	Timeout          time.Duration // Per attempt, including reading the response body
+	MaxAttempts      int           // 1 disables retries
+	BaseDelay        time.Duration // Backoff before the second attempt, doubled for each further one
+	MaxDelay         time.Duration // Upper bound of the backoff; a longer Retry-After is not waited for
+	FailureThreshold int           // Consecutive failed attempts that open the breaker
+	CoolDown         time.Duration // How long an open breaker rejects requests
+
+	// OnStateChange, if not nil, is called when the breaker changes state
+	OnStateChange func(service string, from, to State)
+}
+
+// DefaultPolicy returns the policy used for a service with the given
+// per-attempt timeout
+func DefaultPolicy(timeout time.Duration) Policy {
+	return Policy{
+		Timeout:          timeout,
+		MaxAttempts:      4,
+		BaseDelay:        250 * time.Millisecond,
+		MaxDelay:         10 * time.Second,
+		FailureThreshold: 5,
+		CoolDown:         30 * time.Second,
+	}
+}
+
+// Stats are the counters of a Transport
+type Stats struct {
+	State    string `json:"state"`
+	Requests int64  `json:"requests"` // Calls of RoundTrip
+	Attempts int64  `json:"attempts"` // Requests actually sent, including retries
+	Retries  int64  `json:"retries"`
+	Failures int64  `json:"failures"` // Attempts that failed with an error, 5xx or 429
+	Rejected int64  `json:"rejected"` // Requests refused by the open breaker
+}
+
+// services is the expvar every Transport is published under
+var services = expvar.NewMap("http_services")
+
+// Transport is an http.RoundTripper adding timeouts, retries and a circuit
+// breaker to another one
+type Transport struct {
+	name    string
+	policy  Policy
+	next    http.RoundTripper
+	breaker *Breaker
+
+	requests atomic.Int64
+	attempts atomic.Int64
+	retries  atomic.Int64
+	failures atomic.Int64
+	rejected atomic.Int64
+}
+
+// NewTransport wraps next, http.DefaultTransport if nil, and publishes the
+// transport's Stats under name
+func NewTransport(name string, policy Policy, next http.RoundTripper) *Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	t := &Transport{name: name, policy: policy, next: next}
+	t.breaker = NewBreaker(policy.FailureThreshold, policy.CoolDown, func(from, to State) {
+		if policy.OnStateChange != nil {
+			policy.OnStateChange(name, from, to)
+		}
+	})
+	services.Set(name, expvar.Func(func() any { return t.Stats() }))
+	return t
+}
+
+// NewClient returns an http.Client for the named service using a new
+// Transport. The client itself has no timeout, the policy bounds each
+// attempt.
+func NewClient(name string, policy Policy) *http.Client {
+	return &http.Client{Transport: NewTransport(name, policy, nil)}
+}
+
+// NewLoggingClient is NewClient with breaker state changes written to the
+// standard logger, as every command reports them
+func NewLoggingClient(name string, policy Policy) *http.Client {
+	policy.OnStateChange = func(service string, from, to State) {
+		log.Printf("Circuit breaker for %s: %s -> %s", service, from, to)
+	}
+	return NewClient(name, policy)
+}
+
+// Stats returns the current counters and breaker state
+func (t *Transport) Stats() Stats {
+	return Stats{
+		State:    t.breaker.State().String(),
+		Requests: t.requests.Load(),
+		Attempts: t.attempts.Load(),
+		Retries:  t.retries.Load(),
+		Failures: t.failures.Load(),
+		Rejected: t.rejected.Load(),
+	}
+}
+
+// RoundTrip implements http.RoundTripper. Requests with a body are only
+// retried if the body can be replayed, which is the case for bodies
+// created from a bytes.Buffer, bytes.Reader or strings.Reader.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	attempts := t.policy.MaxAttempts
+	if req.Body != nil && req.GetBody == nil {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := t.breaker.Allow(); err != nil {
+			t.rejected.Add(1)
+			return nil, fmt.Errorf("%s: %w", t.name, err)
+		}
+
+		resp, err := t.try(req, attempt)
+		if req.Context().Err() != nil {
+			// The caller gave up, this says nothing about the service
+			t.breaker.Release()
+			return resp, err
+		}
+		failed := err != nil || resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+		if failed {
+			t.failures.Add(1)
+		}
+		// A rate limit is the service working as intended
+		t.breaker.Record(err == nil && resp.StatusCode < http.StatusInternalServerError)
+		if !failed || attempt >= attempts {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				if retryAfter > t.policy.MaxDelay {
+					return resp, nil
+				}
+				delay = max(delay, retryAfter)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		t.retries.Add(1)
+	}
+}
+
+// try sends one attempt with its own timeout, which stays in force until
+// the response body is closed
+func (t *Transport) try(req *http.Request, attempt int) (*http.Response, error) {
+	t.attempts.Add(1)
+	ctx, cancel := context.WithCancel(req.Context())
+	if t.policy.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(req.Context(), t.policy.Timeout)
+	}
+
+	attemptReq := req.Clone(ctx)
+	if attempt > 1 && req.Body != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		attemptReq.Body = body
+	}
+
+	resp, err := t.next.RoundTrip(attemptReq)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// backoff returns a random delay up to BaseDelay * 2^(attempt-1), capped at
+// MaxDelay ("full jitter")
+func (t *Transport) backoff(attempt int) time.Duration {
+	ceiling := t.policy.BaseDelay << (attempt - 1)
+	if ceiling <= 0 || ceiling > t.policy.MaxDelay {
+		ceiling = t.policy.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an
+// HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// cancelBody releases the attempt's context once the body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,237 @@
+package resilient
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testPolicy() Policy {
+	return Policy{
+		Timeout:          time.Second,
+		MaxAttempts:      3,
+		BaseDelay:        time.Millisecond,
+		MaxDelay:         10 * time.Millisecond,
+		FailureThreshold: 100,
+		CoolDown:         time.Minute,
+	}
+}
+
+func TestTransport_RetriesAndReplaysBody(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "payload" {
+			t.Errorf("Attempt %d got body %q", calls.Load()+1, body)
+		}
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	transport := NewTransport("test-retry", testPolicy(), nil)
+	client := &http.Client{Transport: transport}
+	resp, err := client.Post(server.URL, "text/plain", bytes.NewReader([]byte("payload")))
+	if err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("Unexpected response %d %q", resp.StatusCode, body)
+	}
+	if stats := transport.Stats(); stats.Attempts != 3 || stats.Retries != 2 || stats.Failures != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestTransport_GivesUp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	client := NewClient("test-give-up", testPolicy())
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected the last 502 to be returned, got %d", resp.StatusCode)
+	}
+}
+
+func TestTransport_DoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	resp, err := NewClient("test-client-error", testPolicy()).Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if calls.Load() != 1 {
+		t.Errorf("Expected one attempt, got %d", calls.Load())
+	}
+}
+
+func TestTransport_RetryAfterBeyondMaxDelay(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	resp, err := NewClient("test-retry-after", testPolicy()).Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || calls.Load() != 1 {
+		t.Errorf("Expected the 429 without retrying, got %d after %d attempts", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestTransport_TimeoutPerAttempt(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			<-r.Context().Done() // Hang until the client gives up on this attempt
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	policy := testPolicy()
+	policy.Timeout = 50 * time.Millisecond
+	resp, err := NewClient("test-timeout", policy).Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls.Load() != 2 {
+		t.Errorf("Expected success on the second attempt, got %d after %d attempts", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestTransport_CircuitBreaker(t *testing.T) {
+	var calls atomic.Int32
+	healthy := atomic.Bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	var changes []string
+	policy := testPolicy()
+	policy.MaxAttempts = 1
+	policy.FailureThreshold = 2
+	policy.CoolDown = 20 * time.Millisecond
+	policy.OnStateChange = func(service string, from, to State) {
+		changes = append(changes, from.String()+"->"+to.String())
+	}
+	transport := NewTransport("test-breaker", policy, nil)
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Get %d failed: %v", i, err)
+		}
+		resp.Body.Close()
+	}
+	if _, err := client.Get(server.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected the open breaker not to call the service, got %d calls", calls.Load())
+	}
+
+	time.Sleep(policy.CoolDown)
+	healthy.Store(true)
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Trial request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if len(changes) != len(want) {
+		t.Fatalf("State changes = %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("State changes = %v, want %v", changes, want)
+		}
+	}
+	if stats := transport.Stats(); stats.State != "closed" || stats.Rejected != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestNewLoggingClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	policy := testPolicy()
+	policy.MaxAttempts = 1
+	policy.FailureThreshold = 1
+	resp, err := NewLoggingClient("test-logging", policy).Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	if !strings.Contains(logged.String(), "Circuit breaker for test-logging: closed -> open") {
+		t.Errorf("Breaker change not logged, got %q", logged.String())
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 3, 5, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{"Wed, 05 Mar 2025 12:00:30 GMT", 30 * time.Second, true},
+		{"Wed, 05 Mar 2025 11:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, test := range tests {
+		got, ok := parseRetryAfter(test.value, now)
+		if got != test.want || ok != test.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", test.value, got, ok, test.want, test.ok)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+	"github.com/korjavin/ragtgbot/internal/sparse"
+)
+
+// migrateBatchSize is the number of points copied per scroll and upsert
+const migrateBatchSize = 256
+
+// Plan describes what Migrate will do
+type Plan struct {
+	From   Status
+	Target string // Versioned collection the points are copied into
+
+	// DropLegacy allows deleting a legacy collection, which carries the
+	// alias name itself, once it has been copied. Without it the copy is
+	// made and verified but the legacy collection stays in use.
+	DropLegacy bool
+}
+
+// KeepsLegacy returns true if the plan copies a legacy collection without
+// replacing it by the alias
+func (p Plan) KeepsLegacy() bool {
+	return p.NeedsMigration() && !p.From.Aliased && !p.DropLegacy
+}
+
+// NeedsMigration returns true if the collection is not yet a current
+// version collection behind the alias
+func (p Plan) NeedsMigration() bool {
+	return p.From.Version < CurrentVersion || !p.From.Aliased
+}
+
+func (p Plan) String() string {
+	if !p.NeedsMigration() {
+		return fmt.Sprintf("'%s' -> '%s' (version %d) is up to date", p.From.Alias, p.From.Collection, p.From.Version)
+	}
+	switchStep := fmt.Sprintf("switch alias '%s' to it, keeping '%s'", p.From.Alias, p.From.Collection)
+	if p.KeepsLegacy() {
+		switchStep = fmt.Sprintf("keep legacy '%s' in use until it may be dropped", p.From.Collection)
+	} else if !p.From.Aliased {
+		switchStep = fmt.Sprintf("delete legacy '%s' and create alias '%s' for it", p.From.Collection, p.From.Alias)
+	}
+	return fmt.Sprintf("copy '%s' (version %d) into '%s' (version %d), then %s",
+		p.From.Collection, p.From.Version, p.Target, CurrentVersion, switchStep)
+}
+
+// PlanMigration inspects the collection behind alias and returns the steps
+// needed to bring it to the current version
+func PlanMigration(ctx context.Context, client *qdrant.Client, alias string) (Plan, error) {
+	status, err := Inspect(ctx, client, alias)
+	if err != nil {
+		return Plan{}, err
+	}
+	return Plan{From: status, Target: CollectionName(alias, CurrentVersion)}, nil
+}
+
+// Migrate executes a plan: it copies every point into the target
+// collection, converting vectors to the current layout, checks that all
+// points arrived and only then points the alias at the target. progress,
+// if not nil, is called after each copied batch with the total so far.
+//
+// A legacy collection carries the alias name itself, so it has to be
+// deleted before the alias can be created. That only happens with
+// plan.DropLegacy, after the copy has been verified, and should be done
+// with the bot stopped: points it writes to the legacy collection after
+// the count check are lost. Without DropLegacy the legacy collection is
+// kept and stays in use. Aliased collections are always kept.
+func Migrate(ctx context.Context, client *qdrant.Client, plan Plan, progress func(copied int64)) error {
+	return migrate(ctx, client, plan, migrateBatchSize, progress)
+}
+
+func migrate(ctx context.Context, client *qdrant.Client, plan Plan, batchSize int, progress func(copied int64)) error {
+	if !plan.NeedsMigration() {
+		return nil
+	}
+	source, target := plan.From.Collection, plan.Target
+	if source == target {
+		return fmt.Errorf("collection '%s' is already the migration target", target)
+	}
+
+	if err := createCollection(ctx, client, target, plan.From.Dimension); err != nil {
+		return fmt.Errorf("error creating '%s': %w", target, err)
+	}
+
+	var copied int64
+	var offset *qdrant.PointID
+	for {
+		page, err := client.Scroll(ctx, source, qdrant.ScrollRequest{
+			Limit:       batchSize,
+			Offset:      offset,
+			WithPayload: true,
+			WithVector:  true,
+		})
+		if err != nil {
+			return fmt.Errorf("error reading '%s': %w", source, err)
+		}
+
+		if len(page.Points) > 0 {
+			points := make([]qdrant.Point, len(page.Points))
+			for i, record := range page.Points {
+				points[i] = convert(record)
+			}
+			if err := client.Upsert(ctx, target, points, true); err != nil {
+				return fmt.Errorf("error writing '%s': %w", target, err)
+			}
+			copied += int64(len(points))
+			if progress != nil {
+				progress(copied)
+			}
+		}
+
+		if page.NextPageOffset == nil {
+			break
+		}
+		offset = page.NextPageOffset
+	}
+
+	if plan.KeepsLegacy() {
+		return verifyCopy(ctx, client, source, target)
+	}
+
+	// Counted again right before the switch, not before the copy
+	if err := verifyCopy(ctx, client, source, target); err != nil {
+		return fmt.Errorf("%w, alias not switched", err)
+	}
+	alias := plan.From.Alias
+	if !plan.From.Aliased {
+		if err := client.DeleteCollection(ctx, source); err != nil {
+			return fmt.Errorf("error deleting legacy '%s': %w", source, err)
+		}
+		return client.UpdateAliases(ctx, qdrant.AliasAction{
+			CreateAlias: &qdrant.Alias{AliasName: alias, CollectionName: target},
+		})
+	}
+	return client.UpdateAliases(ctx,
+		qdrant.AliasAction{DeleteAlias: &qdrant.DeleteAlias{AliasName: alias}},
+		qdrant.AliasAction{CreateAlias: &qdrant.Alias{AliasName: alias, CollectionName: target}},
+	)
+}
+
+// verifyCopy checks that target has at least as many points as source
+func verifyCopy(ctx context.Context, client *qdrant.Client, source, target string) error {
+	sourceCount, err := client.Count(ctx, source)
+	if err != nil {
+		return err
+	}
+	targetCount, err := client.Count(ctx, target)
+	if err != nil {
+		return err
+	}
+	if targetCount < sourceCount {
+		return fmt.Errorf("'%s' has %d points but '%s' only %d", source, sourceCount, target, targetCount)
+	}
+	return nil
+}
+
+// convert maps a point of any older layout to the current one: an unnamed
+// vector becomes the "data" vector and the keyword vector is built from
+// the payload text
+func convert(record qdrant.Record) qdrant.Point {
+	dense, ok := record.Vector[DenseVectorName]
+	if !ok {
+		dense = record.Vector[""]
+	}
+	vectors := qdrant.NamedVectors{DenseVectorName: dense}
+
+	if keyword, ok := record.Vector[SparseVectorName]; ok {
+		vectors[SparseVectorName] = keyword
+	} else if text, _ := record.Payload["text"].(string); text != "" {
+		vectors[SparseVectorName] = qdrant.SparseVector(sparse.EncodeDocument(text))
+	}
+
+	return qdrant.Point{ID: record.ID, Vector: vectors, Payload: record.Payload}
+}
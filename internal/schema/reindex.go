@@ -0,0 +1,246 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/korjavin/ragtgbot/internal/embedding"
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+	"github.com/korjavin/ragtgbot/internal/sparse"
+)
+
+const (
+	// reindexBatchSize is the default number of points scrolled, embedded
+	// and written at once
+	reindexBatchSize = 64
+
+	// maxReindexPasses bounds the catch-up passes for points written to
+	// or changed in the source while a pass was running
+	maxReindexPasses = 3
+)
+
+// ReindexPlan describes what Reindex will do
+type ReindexPlan struct {
+	From      Status
+	Target    string // Collection the re-embedded points are written to
+	ModelID   string // Embedding model of the target
+	Dimension int    // Vector size of the target
+	Points    int64  // Points in the source collection
+	Done      int64  // Points already in the target, left by an interrupted run
+}
+
+func (p ReindexPlan) String() string {
+	resume := ""
+	if p.Done > 0 {
+		resume = fmt.Sprintf(", resuming with %d points already there", p.Done)
+	}
+	return fmt.Sprintf("re-embed %d points of '%s' (%d dimensions) with '%s' into '%s' (%d dimensions)%s, then switch alias '%s' to it, keeping '%s'",
+		p.Points, p.From.Collection, p.From.Dimension, p.ModelID, p.Target, p.Dimension, resume, p.From.Alias, p.From.Collection)
+}
+
+// ReindexProgress is reported after every written batch
+type ReindexProgress struct {
+	Pass     int
+	Scanned  int64 // Source points read in this pass
+	Embedded int64 // Points embedded and written in this pass
+	Skipped  int64 // Points without text, which cannot be re-embedded
+}
+
+// ReindexCollectionName returns the collection a model's points are
+// written to: the current version's name followed by the model ID, e.g.
+// "chat_history_v2_ollama-nomic-embed-text"
+func ReindexCollectionName(alias, modelID string) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(modelID) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			slug.WriteRune(r)
+			dash = false
+		} else if !dash && slug.Len() > 0 {
+			slug.WriteByte('-')
+			dash = true
+		}
+	}
+	return CollectionName(alias, CurrentVersion) + "_" + strings.TrimSuffix(slug.String(), "-")
+}
+
+// PlanReindex inspects the collection behind alias and the target
+// collection for embedder's model
+func PlanReindex(ctx context.Context, client *qdrant.Client, alias string, embedder embedding.Embedder) (ReindexPlan, error) {
+	status, err := Inspect(ctx, client, alias)
+	if err != nil {
+		return ReindexPlan{}, err
+	}
+	if !status.Aliased {
+		return ReindexPlan{}, fmt.Errorf("%w: '%s' is not behind an alias yet", ErrIncompatible, status.Collection)
+	}
+
+	plan := ReindexPlan{
+		From:      status,
+		Target:    ReindexCollectionName(alias, embedder.ModelID()),
+		ModelID:   embedder.ModelID(),
+		Dimension: embedder.Dimension(),
+	}
+	if plan.Target == status.Collection {
+		return ReindexPlan{}, fmt.Errorf("'%s' already holds the embeddings of '%s'", status.Collection, plan.ModelID)
+	}
+
+	if plan.Points, err = client.Count(ctx, status.Collection); err != nil {
+		return ReindexPlan{}, err
+	}
+	if plan.Done, err = client.Count(ctx, plan.Target); err != nil && !qdrant.IsNotFound(err) {
+		return ReindexPlan{}, err
+	}
+	return plan, nil
+}
+
+// Reindex executes a plan: it embeds the payload text of every source
+// point with embedder and writes it, with the same ID and payload, into the
+// target collection. Points already in the target with the same payload
+// are skipped, so an interrupted run resumes where it stopped; points the
+// bot has rewritten since are embedded again. Passes are repeated while
+// new or changed points show up in the source; once every point with text
+// is in the target, the alias is switched to it. The source collection is
+// kept.
+func Reindex(ctx context.Context, client *qdrant.Client, plan ReindexPlan, embedder embedding.Embedder, progress func(ReindexProgress)) error {
+	return reindex(ctx, client, plan, embedder, reindexBatchSize, progress)
+}
+
+func reindex(ctx context.Context, client *qdrant.Client, plan ReindexPlan, embedder embedding.Embedder, batchSize int, progress func(ReindexProgress)) error {
+	if embedder.ModelID() != plan.ModelID || embedder.Dimension() != plan.Dimension {
+		return fmt.Errorf("plan is for '%s', embedder is '%s'", plan.ModelID, embedder.ModelID())
+	}
+	if err := createCollection(ctx, client, plan.Target, plan.Dimension); err != nil {
+		return fmt.Errorf("error creating '%s': %w", plan.Target, err)
+	}
+
+	var last ReindexProgress
+	for pass := 1; pass <= maxReindexPasses; pass++ {
+		var err error
+		last, err = reindexPass(ctx, client, plan, embedder, batchSize, pass, progress)
+		if err != nil {
+			return err
+		}
+		if last.Embedded == 0 {
+			break
+		}
+	}
+	if last.Embedded > 0 {
+		// Points written or changed during the last pass may not be copied yet
+		return fmt.Errorf("'%s' was still changing after %d passes, alias not switched; run again", plan.From.Collection, maxReindexPasses)
+	}
+
+	sourceCount, err := client.Count(ctx, plan.From.Collection)
+	if err != nil {
+		return err
+	}
+	targetCount, err := client.Count(ctx, plan.Target)
+	if err != nil {
+		return err
+	}
+	if targetCount < sourceCount-last.Skipped {
+		return fmt.Errorf("'%s' has %d points with text but '%s' only %d, alias not switched; points are still being added, run again",
+			plan.From.Collection, sourceCount-last.Skipped, plan.Target, targetCount)
+	}
+
+	return client.UpdateAliases(ctx,
+		qdrant.AliasAction{DeleteAlias: &qdrant.DeleteAlias{AliasName: plan.From.Alias}},
+		qdrant.AliasAction{CreateAlias: &qdrant.Alias{AliasName: plan.From.Alias, CollectionName: plan.Target}},
+	)
+}
+
+// reindexPass scrolls the whole source once and writes the points missing
+// from the target or outdated there
+func reindexPass(ctx context.Context, client *qdrant.Client, plan ReindexPlan, embedder embedding.Embedder, batchSize, pass int, progress func(ReindexProgress)) (ReindexProgress, error) {
+	state := ReindexProgress{Pass: pass}
+	var offset *qdrant.PointID
+	for {
+		page, err := client.Scroll(ctx, plan.From.Collection, qdrant.ScrollRequest{
+			Limit:       batchSize,
+			Offset:      offset,
+			WithPayload: true,
+		})
+		if err != nil {
+			return state, fmt.Errorf("error reading '%s': %w", plan.From.Collection, err)
+		}
+		state.Scanned += int64(len(page.Points))
+
+		stale, err := staleRecords(ctx, client, plan.Target, page.Points)
+		if err != nil {
+			return state, err
+		}
+
+		var records []qdrant.Record
+		var texts []string
+		for _, record := range stale {
+			text, _ := record.Payload["text"].(string)
+			if text == "" {
+				state.Skipped++
+				continue
+			}
+			records = append(records, record)
+			texts = append(texts, text)
+		}
+
+		if len(records) > 0 {
+			embeddings, err := embedder.Embed(ctx, texts)
+			if err != nil {
+				return state, fmt.Errorf("error embedding %d points: %w", len(texts), err)
+			}
+			points := make([]qdrant.Point, len(records))
+			for i, record := range records {
+				points[i] = qdrant.Point{
+					ID: record.ID,
+					Vector: qdrant.NamedVectors{
+						DenseVectorName:  qdrant.DenseVector(embeddings[i]),
+						SparseVectorName: qdrant.SparseVector(sparse.EncodeDocument(texts[i])),
+					},
+					Payload: record.Payload,
+				}
+			}
+			if err := client.Upsert(ctx, plan.Target, points, true); err != nil {
+				return state, fmt.Errorf("error writing '%s': %w", plan.Target, err)
+			}
+			state.Embedded += int64(len(points))
+		}
+		if progress != nil {
+			progress(state)
+		}
+
+		if page.NextPageOffset == nil {
+			return state, nil
+		}
+		offset = page.NextPageOffset
+	}
+}
+
+// staleRecords returns the records that are not in the collection, or are
+// there with another payload: the bot rewrote the chunk after it was copied
+func staleRecords(ctx context.Context, client *qdrant.Client, collection string, records []qdrant.Record) ([]qdrant.Record, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+	ids := make([]qdrant.PointID, len(records))
+	for i, record := range records {
+		ids[i] = record.ID
+	}
+	existing, err := client.Retrieve(ctx, collection, qdrant.RetrieveRequest{IDs: ids, WithPayload: true})
+	if err != nil {
+		return nil, fmt.Errorf("error checking '%s' for written points: %w", collection, err)
+	}
+
+	written := make(map[qdrant.PointID]map[string]interface{}, len(existing))
+	for _, record := range existing {
+		written[record.ID] = record.Payload
+	}
+	var stale []qdrant.Record
+	for _, record := range records {
+		payload, ok := written[record.ID]
+		if !ok || !reflect.DeepEqual(payload, record.Payload) {
+			stale = append(stale, record)
+		}
+	}
+	return stale, nil
+}
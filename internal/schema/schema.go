@@ -0,0 +1,215 @@
+// Package schema owns the layout of the chat history collection. The data
+// lives in a versioned collection ("chat_history_v2") that both binaries
+// reach through an alias ("chat_history"), so a layout change is a copy
+// into a new collection followed by an atomic alias switch.
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+)
+
+// Schema versions of the chat history collection
+const (
+	VersionUnnamed = 0 // Single unnamed dense vector, created by early uploadbackup
+	VersionNamed   = 1 // Named dense "data" vector
+	VersionHybrid  = 2 // "data" plus the sparse "text" keyword vector
+
+	// CurrentVersion is the layout new collections are created with
+	CurrentVersion = VersionHybrid
+)
+
+const (
+	DenseVectorName  = "data" // Name of the dense embedding vector
+	SparseVectorName = "text" // Name of the sparse keyword vector
+)
+
+// PayloadIndexes are the payload fields used in search filters and their
+// index types
+var PayloadIndexes = map[string]string{
+	"chat_id":         "keyword",
+	"source":          "keyword",
+	"first_timestamp": "integer",
+	"last_timestamp":  "integer",
+}
+
+// ErrIncompatible is returned when the existing collection cannot be used
+// without a migration
+var ErrIncompatible = errors.New("collection schema is incompatible, run the migrate command")
+
+// DimensionError is returned when the collection stores vectors of another
+// size than the embedding model produces
+type DimensionError struct {
+	Collection string
+	Have       int // Dimension of the collection
+	Want       int // Dimension of the embedding model
+}
+
+func (e *DimensionError) Error() string {
+	return fmt.Sprintf("collection '%s' stores %d-dimensional vectors but the embedding model produces %d",
+		e.Collection, e.Have, e.Want)
+}
+
+// Status describes the collection behind an alias
+type Status struct {
+	Alias      string // Name both binaries use
+	Collection string // Collection holding the points
+	Aliased    bool   // False for a legacy collection named like the alias
+	Version    int
+	Dimension  int // Size of the dense vector
+}
+
+// Compatible returns true if the binaries can read and write the collection
+func (s Status) Compatible() bool {
+	return s.Version >= VersionNamed
+}
+
+// KeywordSearch returns true if the collection has the sparse keyword vector
+func (s Status) KeywordSearch() bool {
+	return s.Version >= VersionHybrid
+}
+
+// CollectionName returns the versioned collection name for an alias
+func CollectionName(alias string, version int) string {
+	return fmt.Sprintf("%s_v%d", alias, version)
+}
+
+// Params returns the collection layout of the current version
+func Params(dimension int) qdrant.CollectionParams {
+	return qdrant.CollectionParams{
+		Vectors: qdrant.VectorsConfig{
+			DenseVectorName: {Size: dimension, Distance: "Cosine"},
+		},
+		SparseVectors: map[string]qdrant.SparseVectorParams{
+			SparseVectorName: {Modifier: "idf"}, // Qdrant adds the IDF part of BM25
+		},
+	}
+}
+
+// Detect infers the schema version of a collection from its layout
+func Detect(params qdrant.CollectionParams) int {
+	if _, ok := params.Vectors[DenseVectorName]; !ok {
+		return VersionUnnamed
+	}
+	if _, ok := params.SparseVectors[SparseVectorName]; !ok {
+		return VersionNamed
+	}
+	return VersionHybrid
+}
+
+// Inspect resolves the alias and reports the schema of the collection
+// behind it. A missing collection is reported as an error for which
+// qdrant.IsNotFound is true.
+func Inspect(ctx context.Context, client *qdrant.Client, alias string) (Status, error) {
+	status := Status{Alias: alias, Collection: alias}
+
+	aliases, err := client.ListAliases(ctx)
+	if err != nil {
+		return status, err
+	}
+	for _, a := range aliases {
+		if a.AliasName == alias {
+			status.Collection = a.CollectionName
+			status.Aliased = true
+		}
+	}
+
+	info, err := client.GetCollection(ctx, status.Collection)
+	if err != nil {
+		return status, err
+	}
+
+	// The version is part of the name, only legacy collections need guessing
+	status.Version = Detect(info.Config.Params)
+	if version, ok := parseVersion(alias, status.Collection); ok {
+		status.Version = version
+	}
+	if dense, ok := info.Config.Params.Vectors[DenseVectorName]; ok {
+		status.Dimension = dense.Size
+	} else {
+		status.Dimension = info.Config.Params.Vectors[""].Size
+	}
+	return status, nil
+}
+
+// parseVersion reads the version from a collection name, which may carry
+// the model ID of a reindex after it ("chat_history_v2_ollama-nomic-embed-text")
+func parseVersion(alias, collection string) (int, bool) {
+	suffix, ok := strings.CutPrefix(collection, alias+"_v")
+	if !ok {
+		return 0, false
+	}
+	suffix, _, _ = strings.Cut(suffix, "_")
+	version, err := strconv.Atoi(suffix)
+	return version, err == nil
+}
+
+// Ensure makes sure the alias points at a usable collection with the
+// payload indexes searches rely on, creating the current layout for vectors
+// of the given dimension if there is none. It never deletes or rewrites
+// data: an incompatible collection is reported with ErrIncompatible, one
+// with another vector size with a *DimensionError.
+func Ensure(ctx context.Context, client *qdrant.Client, alias string, dimension int) (Status, error) {
+	status, err := Inspect(ctx, client, alias)
+	if err == nil {
+		if !status.Compatible() {
+			return status, fmt.Errorf("%w: '%s' has schema version %d, need at least %d",
+				ErrIncompatible, status.Collection, status.Version, VersionNamed)
+		}
+		if status.Dimension != dimension {
+			return status, &DimensionError{Collection: status.Collection, Have: status.Dimension, Want: dimension}
+		}
+		return status, createPayloadIndexes(ctx, client, status.Collection)
+	}
+	if !qdrant.IsNotFound(err) {
+		return status, err
+	}
+
+	target := CollectionName(alias, CurrentVersion)
+	if err := createCollection(ctx, client, target, dimension); err != nil {
+		return status, err
+	}
+	err = client.UpdateAliases(ctx, qdrant.AliasAction{
+		CreateAlias: &qdrant.Alias{AliasName: alias, CollectionName: target},
+	})
+	if err != nil {
+		return status, err
+	}
+
+	return Status{
+		Alias:      alias,
+		Collection: target,
+		Aliased:    true,
+		Version:    CurrentVersion,
+		Dimension:  dimension,
+	}, nil
+}
+
+// createCollection creates a collection of the current version with its
+// payload indexes. An existing collection, e.g. left by an interrupted
+// migration, is reused.
+func createCollection(ctx context.Context, client *qdrant.Client, name string, dimension int) error {
+	if _, err := client.GetCollection(ctx, name); qdrant.IsNotFound(err) {
+		if err := client.CreateCollection(ctx, name, Params(dimension)); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	return createPayloadIndexes(ctx, client, name)
+}
+
+// createPayloadIndexes indexes the fields used in search filters
+func createPayloadIndexes(ctx context.Context, client *qdrant.Client, name string) error {
+	for field, fieldSchema := range PayloadIndexes {
+		if err := client.CreatePayloadIndex(ctx, name, field, fieldSchema); err != nil {
+			return fmt.Errorf("error creating payload index on '%s': %w", field, err)
+		}
+	}
+	return nil
+}
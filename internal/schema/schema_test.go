@@ -0,0 +1,443 @@
+package schema
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/korjavin/ragtgbot/internal/qdrant"
+)
+
+// fakeQdrant keeps collections and aliases in memory and implements the
+// endpoints used by this package
+type fakeQdrant struct {
+	mu          sync.Mutex
+	collections map[string]qdrant.CollectionParams
+	points      map[string][]qdrant.Point
+	aliases     map[string]string
+}
+
+func newFakeQdrant() *fakeQdrant {
+	return &fakeQdrant{
+		collections: make(map[string]qdrant.CollectionParams),
+		points:      make(map[string][]qdrant.Point),
+		aliases:     make(map[string]string),
+	}
+}
+
+func (f *fakeQdrant) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	reply := func(result interface{}) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": result, "status": "ok"})
+	}
+	notFound := func() {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"status": {"error": "Not found"}}`))
+	}
+	resolve := func(name string) string {
+		if target, ok := f.aliases[name]; ok {
+			return target
+		}
+		return name
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/aliases":
+		var aliases []qdrant.Alias
+		for alias, collection := range f.aliases {
+			aliases = append(aliases, qdrant.Alias{AliasName: alias, CollectionName: collection})
+		}
+		reply(map[string]interface{}{"aliases": aliases})
+	case r.URL.Path == "/collections/aliases":
+		var req struct {
+			Actions []qdrant.AliasAction `json:"actions"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		for _, action := range req.Actions {
+			if action.DeleteAlias != nil {
+				delete(f.aliases, action.DeleteAlias.AliasName)
+			}
+			if action.CreateAlias != nil {
+				f.aliases[action.CreateAlias.AliasName] = action.CreateAlias.CollectionName
+			}
+		}
+		reply(true)
+	case len(path) == 2 && r.Method == http.MethodGet:
+		params, ok := f.collections[resolve(path[1])]
+		if !ok {
+			notFound()
+			return
+		}
+		reply(map[string]interface{}{"config": map[string]interface{}{"params": params}})
+	case len(path) == 2 && r.Method == http.MethodPut:
+		var params qdrant.CollectionParams
+		json.NewDecoder(r.Body).Decode(&params)
+		f.collections[path[1]] = params
+		reply(true)
+	case len(path) == 2 && r.Method == http.MethodDelete:
+		delete(f.collections, path[1])
+		delete(f.points, path[1])
+		reply(true)
+	case len(path) == 3 && path[2] == "index":
+		reply(map[string]interface{}{"status": "acknowledged"})
+	case len(path) == 3 && path[2] == "points" && r.Method == http.MethodPost:
+		var req qdrant.RetrieveRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		records := []qdrant.Point{}
+		for _, p := range f.points[resolve(path[1])] {
+			for _, id := range req.IDs {
+				if p.ID == id {
+					records = append(records, p)
+				}
+			}
+		}
+		reply(records)
+	case len(path) == 3 && path[2] == "points":
+		var req struct {
+			Points []qdrant.Point `json:"points"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		name := resolve(path[1])
+		for _, point := range req.Points {
+			replaced := false
+			for i, existing := range f.points[name] {
+				if existing.ID == point.ID {
+					f.points[name][i], replaced = point, true
+				}
+			}
+			if !replaced {
+				f.points[name] = append(f.points[name], point)
+			}
+		}
+		reply(map[string]interface{}{"status": "completed"})
+	case len(path) == 4 && path[3] == "count":
+		reply(map[string]interface{}{"count": len(f.points[resolve(path[1])])})
+	case len(path) == 4 && path[3] == "scroll":
+		var req qdrant.ScrollRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		all := f.points[resolve(path[1])]
+		start := 0
+		if req.Offset != nil {
+			for i, p := range all {
+				if p.ID == *req.Offset {
+					start = i
+				}
+			}
+		}
+		end := start + req.Limit
+		var next *qdrant.PointID
+		if end < len(all) {
+			next = &all[end].ID
+		} else {
+			end = len(all)
+		}
+		reply(map[string]interface{}{"points": all[start:end], "next_page_offset": next})
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.Path, http.StatusBadRequest)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		params qdrant.CollectionParams
+		want   int
+	}{
+		{qdrant.CollectionParams{Vectors: qdrant.VectorsConfig{"": {Size: 512}}}, VersionUnnamed},
+		{qdrant.CollectionParams{Vectors: qdrant.VectorsConfig{"data": {Size: 512}}}, VersionNamed},
+		{Params(512), VersionHybrid},
+	}
+	for _, tt := range tests {
+		if got := Detect(tt.params); got != tt.want {
+			t.Errorf("Detect(%v) = %d, want %d", tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestEnsure_CreatesAliasedCollection(t *testing.T) {
+	fake := newFakeQdrant()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	status, err := Ensure(context.Background(), qdrant.NewClient(server.URL, nil), "chat_history", 512)
+	if err != nil {
+		t.Fatalf("Ensure failed: %v", err)
+	}
+	if status.Collection != "chat_history_v2" || !status.Aliased || !status.KeywordSearch() {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	if fake.aliases["chat_history"] != "chat_history_v2" {
+		t.Errorf("Alias not created: %v", fake.aliases)
+	}
+}
+
+func TestEnsure_RefusesIncompatible(t *testing.T) {
+	fake := newFakeQdrant()
+	fake.collections["chat_history"] = qdrant.CollectionParams{Vectors: qdrant.VectorsConfig{"": {Size: 512, Distance: "Cosine"}}}
+	fake.points["chat_history"] = []qdrant.Point{{ID: "1"}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	_, err := Ensure(context.Background(), qdrant.NewClient(server.URL, nil), "chat_history", 512)
+	if !errors.Is(err, ErrIncompatible) {
+		t.Fatalf("Expected ErrIncompatible, got %v", err)
+	}
+	if len(fake.points["chat_history"]) != 1 {
+		t.Error("Ensure must not touch an incompatible collection")
+	}
+}
+
+func TestEnsure_RefusesOtherDimension(t *testing.T) {
+	fake := newFakeQdrant()
+	fake.collections["chat_history_v2"] = Params(512)
+	fake.aliases["chat_history"] = "chat_history_v2"
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	_, err := Ensure(context.Background(), qdrant.NewClient(server.URL, nil), "chat_history", 384)
+	var dimErr *DimensionError
+	if !errors.As(err, &dimErr) || dimErr.Have != 512 || dimErr.Want != 384 {
+		t.Fatalf("Expected a DimensionError 512 vs 384, got %v", err)
+	}
+}
+
+func TestMigrate_LegacyUnnamed(t *testing.T) {
+	fake := newFakeQdrant()
+	fake.collections["chat_history"] = qdrant.CollectionParams{Vectors: qdrant.VectorsConfig{"": {Size: 2, Distance: "Cosine"}}}
+	for _, id := range []qdrant.PointID{"1", "2", "3"} {
+		fake.points["chat_history"] = append(fake.points["chat_history"], qdrant.Point{
+			ID:      id,
+			Vector:  qdrant.NamedVectors{"": qdrant.DenseVector([]float32{1, 0})},
+			Payload: map[string]interface{}{"text": "deploy failed again"},
+		})
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client := qdrant.NewClient(server.URL, nil)
+
+	plan, err := PlanMigration(context.Background(), client, "chat_history")
+	if err != nil {
+		t.Fatalf("PlanMigration failed: %v", err)
+	}
+	if !plan.NeedsMigration() || plan.From.Version != VersionUnnamed || plan.Target != "chat_history_v2" {
+		t.Fatalf("Unexpected plan: %+v", plan)
+	}
+
+	var batches int
+	if err := migrate(context.Background(), client, plan, 2, func(int64) { batches++ }); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if batches != 2 {
+		t.Errorf("Expected 2 batches, got %d", batches)
+	}
+
+	// The legacy collection stays in use until it may be dropped
+	if len(fake.points["chat_history"]) != 3 || len(fake.points["chat_history_v2"]) != 3 {
+		t.Fatalf("Expected the points in both collections, got %d and %d",
+			len(fake.points["chat_history"]), len(fake.points["chat_history_v2"]))
+	}
+	if _, ok := fake.aliases["chat_history"]; ok {
+		t.Fatalf("Alias created while the legacy collection is kept: %v", fake.aliases)
+	}
+
+	// Points the bot wrote meanwhile are copied by the second run
+	fake.points["chat_history"] = append(fake.points["chat_history"], qdrant.Point{
+		ID:      "4",
+		Vector:  qdrant.NamedVectors{"": qdrant.DenseVector([]float32{0, 1})},
+		Payload: map[string]interface{}{"text": "written after the copy"},
+	})
+	plan.DropLegacy = true
+	if err := migrate(context.Background(), client, plan, 2, nil); err != nil {
+		t.Fatalf("Migrate with DropLegacy failed: %v", err)
+	}
+
+	migrated := fake.points["chat_history_v2"]
+	if len(migrated) != 4 {
+		t.Fatalf("Expected 4 migrated points, got %d", len(migrated))
+	}
+	if len(migrated[0].Vector["data"].Dense) != 2 || migrated[0].Vector["text"].Sparse == nil {
+		t.Errorf("Point not converted: %+v", migrated[0].Vector)
+	}
+	if fake.aliases["chat_history"] != "chat_history_v2" {
+		t.Errorf("Alias not switched: %v", fake.aliases)
+	}
+
+	status, err := Inspect(context.Background(), client, "chat_history")
+	if err != nil || status.Version != CurrentVersion || status.Dimension != 2 {
+		t.Errorf("Unexpected status after migration: %+v, %v", status, err)
+	}
+}
+
+func TestMigrate_SwitchesAlias(t *testing.T) {
+	fake := newFakeQdrant()
+	fake.collections["chat_history_v1"] = qdrant.CollectionParams{Vectors: qdrant.VectorsConfig{"data": {Size: 2, Distance: "Cosine"}}}
+	fake.points["chat_history_v1"] = []qdrant.Point{{ID: "1", Vector: qdrant.NamedVectors{"data": qdrant.DenseVector([]float32{0, 1})}}}
+	fake.aliases["chat_history"] = "chat_history_v1"
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client := qdrant.NewClient(server.URL, nil)
+
+	plan, err := PlanMigration(context.Background(), client, "chat_history")
+	if err != nil {
+		t.Fatalf("PlanMigration failed: %v", err)
+	}
+	if err := Migrate(context.Background(), client, plan, nil); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	if fake.aliases["chat_history"] != "chat_history_v2" {
+		t.Errorf("Alias not switched: %v", fake.aliases)
+	}
+	if _, kept := fake.collections["chat_history_v1"]; !kept {
+		t.Error("The previous collection should be kept")
+	}
+}
+
+// fakeEmbedder returns a vector of the text length for every text
+type fakeEmbedder struct {
+	calls int
+}
+
+func (e *fakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.calls++
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embeddings[i] = []float32{float32(len(text)), 0, 1}
+	}
+	return embeddings, nil
+}
+
+func (e *fakeEmbedder) Dimension() int  { return 3 }
+func (e *fakeEmbedder) ModelID() string { return "ollama:nomic-embed-text" }
+
+func reindexFixture() *fakeQdrant {
+	fake := newFakeQdrant()
+	fake.collections["chat_history_v2"] = Params(2)
+	fake.aliases["chat_history"] = "chat_history_v2"
+	for id, text := range []string{"deploy failed", "", "rollback done", "all good"} {
+		fake.points["chat_history_v2"] = append(fake.points["chat_history_v2"], qdrant.Point{
+			ID:      qdrant.PointID(fmt.Sprint(id + 1)),
+			Vector:  qdrant.NamedVectors{"data": qdrant.DenseVector([]float32{1, 0})},
+			Payload: map[string]interface{}{"text": text},
+		})
+	}
+	return fake
+}
+
+func TestReindexCollectionName(t *testing.T) {
+	tests := map[string]string{
+		"ollama:nomic-embed-text":                       "chat_history_v2_ollama-nomic-embed-text",
+		"openai:text-embedding-3-small":                 "chat_history_v2_openai-text-embedding-3-small",
+		"service:http://localhost:8000/embeddings":      "chat_history_v2_service-http-localhost-8000-embeddings",
+		"service:distiluse-base-multilingual-cased-v1/": "chat_history_v2_service-distiluse-base-multilingual-cased-v1",
+	}
+	for modelID, want := range tests {
+		if got := ReindexCollectionName("chat_history", modelID); got != want {
+			t.Errorf("ReindexCollectionName(%q) = %q, want %q", modelID, got, want)
+		}
+		if version, ok := parseVersion("chat_history", want); !ok || version != CurrentVersion {
+			t.Errorf("parseVersion(%q) = %d, %v", want, version, ok)
+		}
+	}
+}
+
+func TestReindex_SwitchesAlias(t *testing.T) {
+	fake := reindexFixture()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client := qdrant.NewClient(server.URL, nil)
+	embedder := &fakeEmbedder{}
+
+	plan, err := PlanReindex(context.Background(), client, "chat_history", embedder)
+	if err != nil {
+		t.Fatalf("PlanReindex failed: %v", err)
+	}
+	if plan.Target != "chat_history_v2_ollama-nomic-embed-text" || plan.Points != 4 || plan.Done != 0 || plan.Dimension != 3 {
+		t.Fatalf("Unexpected plan: %+v", plan)
+	}
+
+	var last ReindexProgress
+	if err := reindex(context.Background(), client, plan, embedder, 2, func(p ReindexProgress) { last = p }); err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	if last.Pass != 2 || last.Embedded != 0 || last.Skipped != 1 {
+		t.Errorf("Expected a final catch-up pass with nothing to do, got %+v", last)
+	}
+
+	reindexed := fake.points[plan.Target]
+	if len(reindexed) != 3 {
+		t.Fatalf("Expected 3 reindexed points, got %d", len(reindexed))
+	}
+	if dense := reindexed[0].Vector["data"].Dense; len(dense) != 3 || dense[0] != float32(len("deploy failed")) {
+		t.Errorf("Point not re-embedded: %+v", reindexed[0].Vector)
+	}
+	if reindexed[0].Vector["text"].Sparse == nil || reindexed[0].Payload["text"] != "deploy failed" {
+		t.Errorf("Keyword vector or payload missing: %+v", reindexed[0])
+	}
+	if fake.aliases["chat_history"] != plan.Target {
+		t.Errorf("Alias not switched: %v", fake.aliases)
+	}
+	if _, kept := fake.collections["chat_history_v2"]; !kept {
+		t.Error("The previous collection should be kept")
+	}
+
+	status, err := Inspect(context.Background(), client, "chat_history")
+	if err != nil || status.Version != CurrentVersion || status.Dimension != 3 {
+		t.Errorf("Unexpected status after reindex: %+v, %v", status, err)
+	}
+	if _, err := PlanReindex(context.Background(), client, "chat_history", embedder); err == nil {
+		t.Error("Expected reindexing into the current collection to be refused")
+	}
+}
+
+func TestReindex_Resumes(t *testing.T) {
+	fake := reindexFixture()
+	target := "chat_history_v2_ollama-nomic-embed-text"
+	fake.collections[target] = Params(3)
+	fake.points[target] = []qdrant.Point{{
+		ID:      "1",
+		Vector:  qdrant.NamedVectors{"data": qdrant.DenseVector([]float32{7, 7, 7})},
+		Payload: map[string]interface{}{"text": "deploy failed"},
+	}, {
+		// The bot has rewritten this chunk since the interrupted run copied it
+		ID:      "3",
+		Vector:  qdrant.NamedVectors{"data": qdrant.DenseVector([]float32{7, 7, 7})},
+		Payload: map[string]interface{}{"text": "rollback"},
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client := qdrant.NewClient(server.URL, nil)
+	embedder := &fakeEmbedder{}
+
+	plan, err := PlanReindex(context.Background(), client, "chat_history", embedder)
+	if err != nil {
+		t.Fatalf("PlanReindex failed: %v", err)
+	}
+	if plan.Done != 2 {
+		t.Errorf("Expected 2 points already done, got %d", plan.Done)
+	}
+	if err := reindex(context.Background(), client, plan, embedder, 10, nil); err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+
+	if len(fake.points[target]) != 3 {
+		t.Errorf("Expected 3 reindexed points, got %d", len(fake.points[target]))
+	}
+	if dense := fake.points[target][0].Vector["data"].Dense; dense[0] != 7 {
+		t.Errorf("Point written by the interrupted run was embedded again: %v", dense)
+	}
+	if updated := fake.points[target][1]; updated.Payload["text"] != "rollback done" || updated.Vector["data"].Dense[0] != float32(len("rollback done")) {
+		t.Errorf("Point changed in the source was not copied again: %+v", updated)
+	}
+	if embedder.calls != 1 {
+		t.Errorf("Expected one embedding batch, got %d", embedder.calls)
+	}
+}
@@ -0,0 +1,103 @@
+// Package sparse builds BM25-style sparse vectors for keyword search in
+// Qdrant. Term frequencies are weighted here; the collection's "idf"
+// modifier adds the inverse document frequency at query time.
+package sparse
+
+import (
+	"hash/fnv"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	k1           = 1.2   // BM25 term frequency saturation
+	b            = 0.75  // BM25 length normalisation
+	avgDocLength = 300.0 // Rough number of tokens in a stored chunk
+)
+
+// Vector is a sparse vector in the form Qdrant expects
+type Vector struct {
+	Indices []uint32  `json:"indices"`
+	Values  []float32 `json:"values"`
+}
+
+// IsEmpty returns true if the vector has no terms
+func (v Vector) IsEmpty() bool {
+	return len(v.Indices) == 0
+}
+
+func isWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Tokenize splits text into lowercase terms. Tokens such as URLs, error
+// codes or SKUs are kept whole and additionally split at punctuation, so
+// both "err-4012" and "4012" match.
+func Tokenize(text string) []string {
+	var tokens []string
+	for _, field := range strings.Fields(strings.ToLower(text)) {
+		field = strings.TrimFunc(field, func(r rune) bool { return !isWordChar(r) })
+		if len([]rune(field)) < 2 {
+			continue
+		}
+		tokens = append(tokens, field)
+
+		parts := strings.FieldsFunc(field, func(r rune) bool { return !isWordChar(r) })
+		if len(parts) > 1 {
+			for _, part := range parts {
+				if len([]rune(part)) >= 2 {
+					tokens = append(tokens, part)
+				}
+			}
+		}
+	}
+	return tokens
+}
+
+func termIndex(term string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(term))
+	return h.Sum32()
+}
+
+func build(weights map[uint32]float32) Vector {
+	v := Vector{
+		Indices: make([]uint32, 0, len(weights)),
+		Values:  make([]float32, 0, len(weights)),
+	}
+	for index := range weights {
+		v.Indices = append(v.Indices, index)
+	}
+	sort.Slice(v.Indices, func(i, j int) bool { return v.Indices[i] < v.Indices[j] })
+	for _, index := range v.Indices {
+		v.Values = append(v.Values, weights[index])
+	}
+	return v
+}
+
+// EncodeDocument returns the sparse vector of a stored chunk
+func EncodeDocument(text string) Vector {
+	tokens := Tokenize(text)
+	counts := make(map[uint32]int)
+	for _, token := range tokens {
+		counts[termIndex(token)]++
+	}
+
+	norm := k1 * (1 - b + b*float64(len(tokens))/avgDocLength)
+	weights := make(map[uint32]float32, len(counts))
+	for index, tf := range counts {
+		weights[index] = float32(float64(tf) * (k1 + 1) / (float64(tf) + norm))
+	}
+	return build(weights)
+}
+
+// EncodeQuery returns the sparse vector of a search query, every distinct
+// term weighs the same
+func EncodeQuery(text string) Vector {
+	weights := make(map[uint32]float32)
+	for _, token := range Tokenize(text) {
+		weights[termIndex(token)] = 1
+	}
+	return build(weights)
+}
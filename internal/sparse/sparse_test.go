@@ -0,0 +1,68 @@
+package sparse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello, World!", []string{"hello", "world"}},
+		{"Error ERR-4012 again", []string{"error", "err-4012", "err", "4012", "again"}},
+		{"see https://example.com/docs", []string{"see", "https://example.com/docs", "https", "example", "com", "docs"}},
+		{"Привет, мир", []string{"привет", "мир"}},
+		{"a I - ok", []string{"ok"}},
+	}
+
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeDocument(t *testing.T) {
+	v := EncodeDocument("sku 123 sku 456")
+	if len(v.Indices) != 3 || len(v.Values) != 3 {
+		t.Fatalf("Expected 3 distinct terms, got %d indices and %d values", len(v.Indices), len(v.Values))
+	}
+	for i := 1; i < len(v.Indices); i++ {
+		if v.Indices[i-1] >= v.Indices[i] {
+			t.Error("Indices should be sorted and unique")
+		}
+	}
+
+	// The repeated term must weigh more than the single ones
+	skuWeight := v.Values[indexOf(v, termIndex("sku"))]
+	otherWeight := v.Values[indexOf(v, termIndex("123"))]
+	if skuWeight <= otherWeight {
+		t.Errorf("Weight of repeated term %f should exceed %f", skuWeight, otherWeight)
+	}
+}
+
+func TestEncodeQuery(t *testing.T) {
+	v := EncodeQuery("where is sku 123? sku!")
+	if len(v.Indices) != 4 {
+		t.Fatalf("Expected 4 distinct terms, got %d", len(v.Indices))
+	}
+	for _, value := range v.Values {
+		if value != 1 {
+			t.Errorf("Query term weight = %f, want 1", value)
+		}
+	}
+	if !EncodeQuery("?!").IsEmpty() {
+		t.Error("Query without terms should encode to an empty vector")
+	}
+}
+
+func indexOf(v Vector, index uint32) int {
+	for i, idx := range v.Indices {
+		if idx == index {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,317 @@
+// Package timerange extracts the time span a question refers to, such as
+// "yesterday", "last week" or "5 марта", so retrieval can be restricted to
+// chunks from that span. English and Russian expressions are understood.
+package timerange
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Range is the half-open time span [From, To)
+type Range struct {
+	From time.Time
+	To   time.Time
+}
+
+// Contains reports whether t falls into the range
+func (r Range) Contains(t time.Time) bool {
+	return !t.Before(r.From) && t.Before(r.To)
+}
+
+type unit int
+
+const (
+	day unit = iota
+	week
+	month
+	year
+)
+
+type rule struct {
+	re    *regexp.Regexp
+	parse func(m []string, now time.Time) (Range, bool)
+}
+
+// word wraps a pattern in letter boundaries. Go's \b only knows ASCII
+// letters, which doesn't work for Cyrillic.
+func word(pattern string) *regexp.Regexp {
+	return regexp.MustCompile(`(?:^|[^\p{L}\p{N}])(?:` + pattern + `)(?:[^\p{L}\p{N}]|$)`)
+}
+
+const (
+	enMonths = `january|february|march|april|may|june|july|august|september|october|november|december`
+	ruMonths = `январ[ьяе]|феврал[ьяе]|март[ае]?|апрел[ьяе]|ма[йяе]|июн[ьяе]|июл[ьяе]|август[ае]?|сентябр[ьяе]|октябр[ьяе]|ноябр[ьяе]|декабр[ьяе]`
+	allMonth = enMonths + `|` + ruMonths
+	enDays   = `monday|tuesday|wednesday|thursday|friday|saturday|sunday`
+	ruDays   = `понедельник|вторник|среду|четверг|пятницу|субботу|воскресенье` // As after "в": в среду
+	units    = `days?|weeks?|months?|years?|день|дня|дней|сутки|суток|недел[юиья]|месяц[аев]*|год[а]?|лет`
+)
+
+// environmentNoun matches the genitive nouns that make "в среду" mean "into
+// the environment": в среду разработки, тестирования, продакшена
+var environmentNoun = regexp.MustCompile(`(?:ки|ния|ции|ства|ера|ена)$`)
+
+var monthPrefixes = []struct {
+	prefix string
+	month  time.Month
+}{
+	{"jan", time.January}, {"feb", time.February}, {"mar", time.March}, {"apr", time.April},
+	{"may", time.May}, {"jun", time.June}, {"jul", time.July}, {"aug", time.August},
+	{"sep", time.September}, {"oct", time.October}, {"nov", time.November}, {"dec", time.December},
+	{"янв", time.January}, {"фев", time.February}, {"мар", time.March}, {"апр", time.April},
+	{"ма", time.May}, {"июн", time.June}, {"июл", time.July}, {"авг", time.August},
+	{"сен", time.September}, {"окт", time.October}, {"ноя", time.November}, {"дек", time.December},
+}
+
+var weekdayPrefixes = []struct {
+	prefix  string
+	weekday time.Weekday
+}{
+	{"mon", time.Monday}, {"tue", time.Tuesday}, {"wed", time.Wednesday}, {"thu", time.Thursday},
+	{"fri", time.Friday}, {"sat", time.Saturday}, {"sun", time.Sunday},
+	{"пон", time.Monday}, {"вто", time.Tuesday}, {"сре", time.Wednesday}, {"чет", time.Thursday},
+	{"пят", time.Friday}, {"суб", time.Saturday}, {"вос", time.Sunday},
+}
+
+func parseMonth(s string) time.Month {
+	for _, p := range monthPrefixes {
+		if strings.HasPrefix(s, p.prefix) {
+			return p.month
+		}
+	}
+	return 0
+}
+
+func parseWeekday(s string) time.Weekday {
+	for _, p := range weekdayPrefixes {
+		if strings.HasPrefix(s, p.prefix) {
+			return p.weekday
+		}
+	}
+	return -1
+}
+
+func parseUnit(s string) unit {
+	switch {
+	case strings.HasPrefix(s, "week"), strings.HasPrefix(s, "недел"):
+		return week
+	case strings.HasPrefix(s, "month"), strings.HasPrefix(s, "месяц"):
+		return month
+	case strings.HasPrefix(s, "year"), strings.HasPrefix(s, "год"), s == "лет":
+		return year
+	default:
+		return day
+	}
+}
+
+// count parses an optional number, a missing number ("a week ago") means 1
+func count(s string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+func startOfWeek(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7 // Weeks start on Monday
+	return startOfDay(t).AddDate(0, 0, -offset)
+}
+
+func startOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+func startOfYear(t time.Time) time.Time {
+	return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location())
+}
+
+func dayRange(t time.Time) Range {
+	from := startOfDay(t)
+	return Range{From: from, To: from.AddDate(0, 0, 1)}
+}
+
+func monthRange(y int, m time.Month, loc *time.Location) Range {
+	from := time.Date(y, m, 1, 0, 0, 0, 0, loc)
+	return Range{From: from, To: from.AddDate(0, 1, 0)}
+}
+
+// calendarRange returns the calendar unit containing t
+func calendarRange(t time.Time, u unit) Range {
+	switch u {
+	case week:
+		from := startOfWeek(t)
+		return Range{From: from, To: from.AddDate(0, 0, 7)}
+	case month:
+		return monthRange(t.Year(), t.Month(), t.Location())
+	case year:
+		from := startOfYear(t)
+		return Range{From: from, To: from.AddDate(1, 0, 0)}
+	default:
+		return dayRange(t)
+	}
+}
+
+func shift(t time.Time, u unit, n int) time.Time {
+	switch u {
+	case week:
+		return t.AddDate(0, 0, -7*n)
+	case month:
+		return t.AddDate(0, -n, 0)
+	case year:
+		return t.AddDate(-n, 0, 0)
+	default:
+		return t.AddDate(0, 0, -n)
+	}
+}
+
+// validDate builds a date and rejects overflow such as 31.02
+func validDate(y int, m time.Month, d int, loc *time.Location) (time.Time, bool) {
+	t := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	if t.Year() != y || t.Month() != m || t.Day() != d {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// pastYear picks the year for a date given without one: this year, unless
+// that is still in the future
+func pastYear(m time.Month, d int, now time.Time) int {
+	if time.Date(now.Year(), m, d, 0, 0, 0, 0, now.Location()).After(now) {
+		return now.Year() - 1
+	}
+	return now.Year()
+}
+
+func yearOrPast(s string, m time.Month, d int, now time.Time) int {
+	if y, err := strconv.Atoi(s); err == nil {
+		if y < 100 {
+			y += 2000
+		}
+		return y
+	}
+	return pastYear(m, d, now)
+}
+
+func dateRange(y int, m time.Month, d int, now time.Time) (Range, bool) {
+	t, ok := validDate(y, m, d, now.Location())
+	if !ok {
+		return Range{}, false
+	}
+	return dayRange(t), true
+}
+
+// Rules are tried in order, more specific expressions first
+var rules = []rule{
+	// 2024-03-05
+	{word(`(\d{4})-(\d{1,2})-(\d{1,2})`), func(m []string, now time.Time) (Range, bool) {
+		y, _ := strconv.Atoi(m[1])
+		mo, _ := strconv.Atoi(m[2])
+		d, _ := strconv.Atoi(m[3])
+		return dateRange(y, time.Month(mo), d, now)
+	}},
+	// 05.03.2024, 05.03.24
+	{word(`(\d{1,2})\.(\d{1,2})\.(\d{4}|\d{2})`), func(m []string, now time.Time) (Range, bool) {
+		d, _ := strconv.Atoi(m[1])
+		mo, _ := strconv.Atoi(m[2])
+		y := yearOrPast(m[3], time.Month(mo), d, now)
+		return dateRange(y, time.Month(mo), d, now)
+	}},
+	// 5 march 2024, 5th of march, 5 марта
+	{word(`(\d{1,2})(?:st|nd|rd|th)?\s+(?:of\s+)?(` + allMonth + `)(?:\s+(\d{4}))?`), func(m []string, now time.Time) (Range, bool) {
+		d, _ := strconv.Atoi(m[1])
+		mo := parseMonth(m[2])
+		return dateRange(yearOrPast(m[3], mo, d, now), mo, d, now)
+	}},
+	// march 5, march 5th 2024
+	{word(`(` + enMonths + `)\s+(\d{1,2})(?:st|nd|rd|th)?(?:,?\s+(\d{4}))?`), func(m []string, now time.Time) (Range, bool) {
+		mo := parseMonth(m[1])
+		d, _ := strconv.Atoi(m[2])
+		return dateRange(yearOrPast(m[3], mo, d, now), mo, d, now)
+	}},
+	// march 2024, в марте 2024
+	{word(`(` + allMonth + `)\s+(\d{4})`), func(m []string, now time.Time) (Range, bool) {
+		y, _ := strconv.Atoi(m[2])
+		return monthRange(y, parseMonth(m[1]), now.Location()), true
+	}},
+	// 3 days ago, a week ago, 2 недели назад, неделю назад
+	{word(`(?:(\d+)|an?)?\s*(` + units + `)\s+(?:ago|назад)`), func(m []string, now time.Time) (Range, bool) {
+		u := parseUnit(m[2])
+		return calendarRange(shift(now, u, count(m[1])), u), true
+	}},
+	// last 3 days, past week, за последние 2 недели, за последнюю неделю
+	{word(`(?:last|past)\s+(\d+)\s+(` + units + `)|past\s+()(` + units + `)|(?:за\s+)?последн(?:ие|ий|юю|ее)\s+(\d+\s+)?(` + units + `)`), func(m []string, now time.Time) (Range, bool) {
+		u := parseUnit(m[2] + m[4] + m[6])
+		n := count(m[1] + m[3] + m[5])
+		return Range{From: startOfDay(shift(now, u, n)), To: startOfDay(now).AddDate(0, 0, 1)}, true
+	}},
+	{word(`day before yesterday|позавчера`), func(m []string, now time.Time) (Range, bool) {
+		return dayRange(now.AddDate(0, 0, -2)), true
+	}},
+	{word(`yesterday|вчера|вчерашн\p{L}*`), func(m []string, now time.Time) (Range, bool) {
+		return dayRange(now.AddDate(0, 0, -1)), true
+	}},
+	{word(`today|tonight|this morning|сегодня|сегодняшн\p{L}*`), func(m []string, now time.Time) (Range, bool) {
+		return dayRange(now), true
+	}},
+	{word(`(?:last|previous)\s+(week|month|year)|(?:на\s+)?прошл(?:ой|ая|ую)\s+(недел[юиея])|(?:в\s+)?прошл(?:ом|ый)\s+(месяц[е]?|год[у]?)`), func(m []string, now time.Time) (Range, bool) {
+		u := parseUnit(m[1] + m[2] + m[3])
+		return calendarRange(shift(now, u, 1), u), true
+	}},
+	{word(`this\s+(week|month|year)|(?:на\s+)?эт(?:ой|а|у)\s+(недел[юиея])|(?:в\s+)?эт(?:ом|от)\s+(месяц[е]?|год[у]?)`), func(m []string, now time.Time) (Range, bool) {
+		u := parseUnit(m[1] + m[2] + m[3])
+		return calendarRange(now, u), true
+	}},
+	// on monday, last friday, в пятницу, в прошлую среду. Only after a
+	// preposition and not followed by a noun: "среда" alone, or in "в среду
+	// разработки", is as likely to mean an environment. "last friday" asked
+	// on a Friday is a week ago, "on friday" today.
+	{word(`(on|last|past|previous)\s+(` + enDays + `)|во?\s+(прошл(?:ый|ую|ое)\s+)?(` + ruDays + `)(?:\s+(\p{L}+))?`), func(m []string, now time.Time) (Range, bool) {
+		if m[4] == "среду" && m[3] == "" && environmentNoun.MatchString(m[5]) {
+			return Range{}, false
+		}
+		wd := parseWeekday(m[2] + m[4])
+		back := (int(now.Weekday()) - int(wd) + 7) % 7
+		if back == 0 && (m[1] != "" && m[1] != "on" || m[3] != "") {
+			back = 7
+		}
+		return dayRange(now.AddDate(0, 0, -back)), true
+	}},
+	// in march, в марте: the latest such month that has started
+	{word(`(?:in|во?)\s+(` + allMonth + `)`), func(m []string, now time.Time) (Range, bool) {
+		mo := parseMonth(m[1])
+		y := now.Year()
+		if mo > now.Month() {
+			y--
+		}
+		return monthRange(y, mo, now.Location()), true
+	}},
+}
+
+// Parse looks for a date expression in query and returns the time span it
+// refers to, interpreted in loc relative to now. The second result is false
+// if the query contains no date expression.
+func Parse(query string, now time.Time, loc *time.Location) (Range, bool) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	now = now.In(loc)
+	text := strings.ToLower(query)
+
+	for _, r := range rules {
+		if m := r.re.FindStringSubmatch(text); m != nil {
+			if rng, ok := r.parse(m, now); ok {
+				return rng, true
+			}
+		}
+	}
+	return Range{}, false
+}
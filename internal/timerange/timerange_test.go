@@ -0,0 +1,118 @@
+package timerange
+
+import (
+	"testing"
+	"time"
+)
+
+// Wednesday, 2025-04-16 15:30 in the chat's time zone
+var (
+	moscow = time.FixedZone("MSK", 3*3600)
+	now    = time.Date(2025, time.April, 16, 15, 30, 0, 0, moscow)
+)
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, moscow)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query    string
+		from, to time.Time
+	}{
+		{"what did we discuss yesterday about the project?", date(2025, 4, 15), date(2025, 4, 16)},
+		{"что обсуждали вчера?", date(2025, 4, 15), date(2025, 4, 16)},
+		{"what happened today", date(2025, 4, 16), date(2025, 4, 17)},
+		{"о чём говорили сегодня", date(2025, 4, 16), date(2025, 4, 17)},
+		{"the day before yesterday", date(2025, 4, 14), date(2025, 4, 15)},
+		{"позавчера", date(2025, 4, 14), date(2025, 4, 15)},
+		{"this week", date(2025, 4, 14), date(2025, 4, 21)},
+		{"что было на этой неделе", date(2025, 4, 14), date(2025, 4, 21)},
+		{"last week", date(2025, 4, 7), date(2025, 4, 14)},
+		{"на прошлой неделе", date(2025, 4, 7), date(2025, 4, 14)},
+		{"last month", date(2025, 3, 1), date(2025, 4, 1)},
+		{"в прошлом месяце", date(2025, 3, 1), date(2025, 4, 1)},
+		{"в этом году", date(2025, 1, 1), date(2026, 1, 1)},
+		{"last year", date(2024, 1, 1), date(2025, 1, 1)},
+		{"3 days ago", date(2025, 4, 13), date(2025, 4, 14)},
+		{"a week ago", date(2025, 4, 7), date(2025, 4, 14)},
+		{"2 дня назад", date(2025, 4, 14), date(2025, 4, 15)},
+		{"last 3 days", date(2025, 4, 13), date(2025, 4, 17)},
+		{"за последние 2 недели", date(2025, 4, 2), date(2025, 4, 17)},
+		{"за последнюю неделю", date(2025, 4, 9), date(2025, 4, 17)},
+		{"past month", date(2025, 3, 16), date(2025, 4, 17)},
+		{"on monday", date(2025, 4, 14), date(2025, 4, 15)},
+		{"в пятницу", date(2025, 4, 11), date(2025, 4, 12)},
+		{"в среду", date(2025, 4, 16), date(2025, 4, 17)},
+		{"last friday", date(2025, 4, 11), date(2025, 4, 12)},
+		{"что писали в прошлую субботу", date(2025, 4, 12), date(2025, 4, 13)},
+		{"во вторник", date(2025, 4, 15), date(2025, 4, 16)},
+		{"last wednesday", date(2025, 4, 9), date(2025, 4, 10)},
+		{"что писали в прошлую среду", date(2025, 4, 9), date(2025, 4, 10)},
+		{"в среду вечером", date(2025, 4, 16), date(2025, 4, 17)},
+		{"2024-12-31", date(2024, 12, 31), date(2025, 1, 1)},
+		{"05.03.2025", date(2025, 3, 5), date(2025, 3, 6)},
+		{"5 марта", date(2025, 3, 5), date(2025, 3, 6)},
+		{"on the 5th of may", date(2024, 5, 5), date(2024, 5, 6)},
+		{"march 5th", date(2025, 3, 5), date(2025, 3, 6)},
+		{"31 декабря 2023", date(2023, 12, 31), date(2024, 1, 1)},
+		{"march 2024", date(2024, 3, 1), date(2024, 4, 1)},
+		{"in march", date(2025, 3, 1), date(2025, 4, 1)},
+		{"в мае", date(2024, 5, 1), date(2024, 6, 1)},
+		{"в апреле", date(2025, 4, 1), date(2025, 5, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, ok := Parse(tt.query, now, moscow)
+			if !ok {
+				t.Fatalf("Parse(%q) found no range", tt.query)
+			}
+			if !got.From.Equal(tt.from) || !got.To.Equal(tt.to) {
+				t.Errorf("Parse(%q) = [%s, %s), want [%s, %s)", tt.query, got.From, got.To, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+func TestParse_NoDate(t *testing.T) {
+	queries := []string{
+		"how do I configure qdrant?",
+		"какой пароль от wifi",
+		"version 1.2 is broken",
+		"who bought the pigeon feeder",
+		"31.02.2025",
+		"какая переменная среды нужна для qdrant",
+		"настройка тестовой среды",
+		"в тестовой среде не работает",
+		"friday deploy checklist",
+		"перенести в среду разработки",
+	}
+	for _, q := range queries {
+		if got, ok := Parse(q, now, moscow); ok {
+			t.Errorf("Parse(%q) = [%s, %s), want no range", q, got.From, got.To)
+		}
+	}
+}
+
+func TestParse_TimeZone(t *testing.T) {
+	// 23:30 UTC on the 15th is already the 16th in Moscow
+	utcNow := time.Date(2025, time.April, 15, 23, 30, 0, 0, time.UTC)
+	got, ok := Parse("yesterday", utcNow, moscow)
+	if !ok {
+		t.Fatal("Parse found no range")
+	}
+	if !got.From.Equal(date(2025, 4, 15)) {
+		t.Errorf("Parse(yesterday) from = %s, want %s", got.From, date(2025, 4, 15))
+	}
+}
+
+func TestRange_Contains(t *testing.T) {
+	r := Range{From: date(2025, 4, 15), To: date(2025, 4, 16)}
+	if !r.Contains(date(2025, 4, 15)) {
+		t.Error("Range should contain its start")
+	}
+	if r.Contains(date(2025, 4, 16)) {
+		t.Error("Range should not contain its end")
+	}
+}
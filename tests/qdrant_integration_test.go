@@ -2,14 +2,18 @@ package tests
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"testing"
 	"time"
+
+	"github.com/korjavin/ragtgbot/internal/qdrant"
 )
 
 const (
@@ -75,247 +79,65 @@ func getEmbeddings(texts []string) ([]float32, error) {
 	return embeddings, nil
 }
 
-// Function to save a message to Qdrant using HTTP API
-func saveToQdrant(collectionName string, messageID int64, text string, username string, embedding []float32) error {
+// Function to save a message to Qdrant
+func saveToQdrant(client *qdrant.Client, collectionName string, messageID int64, text string, username string, embedding []float32) error {
 	log.Printf("Saving message to Qdrant with ID: %d", messageID)
 
-	// Qdrant saving logic using HTTP API
-	qdrantURL := fmt.Sprintf("%s/collections/%s/points", qdrantServiceAddress, collectionName)
-	log.Printf("Using Qdrant URL: %s", qdrantURL)
-
-	// Convert float32 slice to interface{} slice for JSON marshaling
-	embeddingInterface := make([]interface{}, len(embedding))
-	for i, v := range embedding {
-		embeddingInterface[i] = v
-	}
-
-	point := map[string]interface{}{
-		"id": messageID,
-		"vector": map[string]interface{}{
-			"data": embeddingInterface,
+	point := qdrant.Point{
+		ID: qdrant.PointID(strconv.FormatInt(messageID, 10)),
+		Vector: qdrant.NamedVectors{
+			"data": qdrant.DenseVector(embedding),
 		},
-		"payload": map[string]string{
+		Payload: map[string]interface{}{
 			"text":     text,
 			"username": username,
 		},
 	}
 
-	requestBody, err := json.Marshal(map[string][]map[string]interface{}{
-		"points": {point},
-	})
-	if err != nil {
-		log.Printf("Error marshaling point data: %v", err)
-		return err
-	}
-
-	req, err := http.NewRequest(http.MethodPut, qdrantURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		log.Printf("Error creating HTTP request: %v", err)
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error sending HTTP request: %v", err)
-		return err
-	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response body: %v", err)
+	if err := client.Upsert(context.Background(), collectionName, []qdrant.Point{point}, false); err != nil {
+		log.Printf("Error saving point to Qdrant: %v", err)
 		return err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error response from Qdrant: %s", string(respBody))
-		return fmt.Errorf("error response from Qdrant: %s", string(respBody))
-	}
-
 	log.Printf("Successfully saved message to Qdrant with ID: %d", messageID)
 	return nil
 }
 
-// Function to search for similar messages in Qdrant using HTTP API
-func searchQdrant(collectionName string, embedding []float32, limit int) ([]map[string]interface{}, error) {
+// Function to search for similar messages in Qdrant
+func searchQdrant(client *qdrant.Client, collectionName string, embedding []float32, limit int) ([]qdrant.ScoredPoint, error) {
 	log.Printf("Searching Qdrant for similar messages with limit: %d", limit)
 
-	// Qdrant search logic using HTTP API
-	qdrantURL := fmt.Sprintf("%s/collections/%s/points/search", qdrantServiceAddress, collectionName)
-	log.Printf("Using Qdrant URL: %s", qdrantURL)
-
-	// Convert float32 slice to interface{} slice for JSON marshaling
-	embeddingInterface := make([]interface{}, len(embedding))
-	for i, v := range embedding {
-		embeddingInterface[i] = v
-	}
-
-	searchRequest := map[string]interface{}{
-		"vector": map[string]interface{}{
-			"name":   "data",
-			"vector": embeddingInterface,
+	results, err := client.Search(context.Background(), collectionName, qdrant.SearchRequest{
+		Vector: qdrant.NamedVectorQuery{
+			Name:   "data",
+			Vector: qdrant.DenseVector(embedding),
 		},
-		"limit":        limit,
-		"with_payload": true,
-	}
-
-	requestBody, err := json.Marshal(searchRequest)
-	if err != nil {
-		log.Printf("Error marshaling search request: %v", err)
-		return nil, err
-	}
-
-	// Log the request body for debugging
-	log.Printf("Search request body: %s", string(requestBody))
-
-	req, err := http.NewRequest(http.MethodPost, qdrantURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		log.Printf("Error creating HTTP request: %v", err)
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error sending HTTP request: %v", err)
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error response from Qdrant: %s", string(respBody))
-		return nil, fmt.Errorf("error response from Qdrant: %s", string(respBody))
-	}
-
-	// Parse the response
-	var searchResult map[string]interface{}
-	err = json.Unmarshal(respBody, &searchResult)
+		Limit:       limit,
+		WithPayload: true,
+	})
 	if err != nil {
-		log.Printf("Error unmarshaling search result: %v", err)
+		log.Printf("Error searching Qdrant: %v", err)
 		return nil, err
 	}
 
-	// Debug: Print the raw search result
-	rawJSON, _ := json.MarshalIndent(searchResult, "", "  ")
-	log.Printf("Raw search result: %s", string(rawJSON))
-
-	// Extract the result array
-	resultArray, ok := searchResult["result"].([]interface{})
-	if !ok {
-		log.Printf("Error: result field is not an array")
-		return nil, fmt.Errorf("result field is not an array")
-	}
-
-	// Debug: Print the first result if available
-	if len(resultArray) > 0 {
-		firstResult, _ := json.MarshalIndent(resultArray[0], "", "  ")
-		log.Printf("First result: %s", string(firstResult))
-	}
-
-	// Convert to a more usable format
-	results := make([]map[string]interface{}, len(resultArray))
-	for i, r := range resultArray {
-		result, ok := r.(map[string]interface{})
-		if !ok {
-			log.Printf("Error: result item is not a map")
-			return nil, fmt.Errorf("result item is not a map")
-		}
-		results[i] = result
-	}
-
 	log.Printf("Found %d results in Qdrant", len(results))
 	return results, nil
 }
 
-// Function to delete a collection
-func deleteQdrantCollection(collectionName string) error {
-	log.Printf("Deleting collection '%s'...", collectionName)
-
-	// Delete collection
-	qdrantURL := fmt.Sprintf("%s/collections/%s", qdrantServiceAddress, collectionName)
-	req, err := http.NewRequest(http.MethodDelete, qdrantURL, nil)
-	if err != nil {
-		log.Printf("Error creating HTTP request: %v", err)
-		return err
-	}
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error sending HTTP request: %v", err)
-		return err
-	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error response from Qdrant: %s", string(respBody))
-		return fmt.Errorf("error response from Qdrant: %s", string(respBody))
-	}
-
-	log.Printf("Collection '%s' deleted successfully", collectionName)
-	return nil
-}
-
 // Function to create a collection
-func createQdrantCollection(collectionName string) error {
+func createQdrantCollection(client *qdrant.Client, collectionName string) error {
 	log.Printf("Creating collection '%s'...", collectionName)
 
-	// Create collection
-	qdrantURL := fmt.Sprintf("%s/collections/%s", qdrantServiceAddress, collectionName)
-	requestBody, err := json.Marshal(map[string]interface{}{
-		"vectors": map[string]interface{}{
-			"data": map[string]interface{}{
-				"size":     512, // Embedding size
-				"distance": "Cosine",
-			},
+	err := client.CreateCollection(context.Background(), collectionName, qdrant.CollectionParams{
+		Vectors: qdrant.VectorsConfig{
+			"data": {Size: 512, Distance: "Cosine"}, // Embedding size
 		},
 	})
 	if err != nil {
-		log.Printf("Error marshaling collection creation request: %v", err)
+		log.Printf("Error creating collection: %v", err)
 		return err
 	}
 
-	req, err := http.NewRequest(http.MethodPut, qdrantURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		log.Printf("Error creating HTTP request: %v", err)
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error sending HTTP request: %v", err)
-		return err
-	}
-	defer resp.Body.Close()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Error response from Qdrant: %s", string(respBody))
-		return fmt.Errorf("error response from Qdrant: %s", string(respBody))
-	}
-
 	log.Printf("Collection '%s' created successfully", collectionName)
 	return nil
 }
@@ -332,14 +154,16 @@ func TestSaveAndSearchEmbeddings(t *testing.T) {
 		t.Skip("Skipping integration test: LOCAL_SERVICES not set")
 	}
 
+	client := qdrant.NewClient(qdrantServiceAddress, nil)
+
 	// Create a test collection
-	err := createQdrantCollection(testCollectionName)
+	err := createQdrantCollection(client, testCollectionName)
 	if err != nil {
 		t.Fatalf("Failed to create test collection: %v", err)
 	}
 	defer func() {
 		// Clean up: delete the test collection
-		err := deleteQdrantCollection(testCollectionName)
+		err := client.DeleteCollection(context.Background(), testCollectionName)
 		if err != nil {
 			t.Logf("Failed to delete test collection: %v", err)
 		}
@@ -363,7 +187,7 @@ func TestSaveAndSearchEmbeddings(t *testing.T) {
 
 		// Save to Qdrant
 		id := time.Now().UnixNano() + int64(i)
-		err = saveToQdrant(testCollectionName, id, msg, "test_user", embedding)
+		err = saveToQdrant(client, testCollectionName, id, msg, "test_user", embedding)
 		if err != nil {
 			t.Fatalf("Failed to save message %d to Qdrant: %v", i, err)
 		}
@@ -378,7 +202,7 @@ func TestSaveAndSearchEmbeddings(t *testing.T) {
 		t.Fatalf("Failed to get embeddings for search query: %v", err)
 	}
 
-	results, err := searchQdrant(testCollectionName, searchEmbedding, 5)
+	results, err := searchQdrant(client, testCollectionName, searchEmbedding, 5)
 	if err != nil {
 		t.Fatalf("Failed to search Qdrant: %v", err)
 	}
@@ -389,47 +213,11 @@ func TestSaveAndSearchEmbeddings(t *testing.T) {
 	} else {
 		t.Logf("Found %d results for 'test'", len(results))
 
-		// Debug: Print the structure of the first result
-		if len(results) > 0 {
-			for k, v := range results[0] {
-				t.Logf("Result key: %s, type: %T", k, v)
-			}
-		}
-
 		// Check that the top results contain "test"
 		foundTestMessage := false
 		for _, result := range results {
-			// Try to extract payload in different ways
-			var text string
-			var found bool
-
-			// Try direct access to payload.text
-			if payload, ok := result["payload"].(map[string]interface{}); ok {
-				if textVal, ok := payload["text"].(string); ok {
-					text = textVal
-					found = true
-				}
-			}
-
-			// If not found, try to look for a document field
-			if !found {
-				if doc, ok := result["document"].(map[string]interface{}); ok {
-					if textVal, ok := doc["text"].(string); ok {
-						text = textVal
-						found = true
-					}
-				}
-			}
-
-			// If still not found, try to look for a text field directly
-			if !found {
-				if textVal, ok := result["text"].(string); ok {
-					text = textVal
-					found = true
-				}
-			}
-
-			if found && (text == "test" || text == "test 1" || text == "test 2") {
+			text := result.PayloadString("text")
+			if text == "test" || text == "test 1" || text == "test 2" {
 				foundTestMessage = true
 				t.Logf("Found test message: %s", text)
 			}